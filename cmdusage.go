@@ -7,5 +7,8 @@
 //	ultra fast, parallel, find command
 //
 //	           locate - locate files using boolean expressions
+//	            usage - display the disk usage of directories, including all of the files and directories below them
+//	            index - build, update and query an index of the files and directories below a set of starting locations
+//	             diff - report the files and directories that have been added, removed, renamed, resized, modified or had their permissions or owner changed between two snapshots, ie. indexes, or between a snapshot and the current tree
 //	expression-syntax - show help on the expression syntax and matching operations
 package main
@@ -0,0 +1,96 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import "strings"
+
+// splitComparison splits a value into its leading comparison operator,
+// if any, and the remainder of the value.
+func splitComparison(text string) (string, string) {
+	for _, op := range []string{"<=", ">=", "==", "<", ">", "="} {
+		if strings.HasPrefix(text, op) {
+			if op == "==" {
+				op = "="
+			}
+			return op, text[len(op):]
+		}
+	}
+	return "", text
+}
+
+// comparisonOperands are the operands that may be written using a
+// comparison operator in place of =, eg. mtime<7d rather than mtime=<7d.
+var comparisonOperands = []string{"mtime", "atime", "ctime", "size", "entries", "depth", "nlink"}
+
+// bareOperands are the operands that are written without a value,
+// eg. prune.
+var bareOperands = []string{"prune"}
+
+// rewriteComparisons rewrites operands written using comparison
+// operators, such as mtime<7d, into the name=value form accepted by the
+// expression parser, ie. mtime=<7d. Operands that are written without
+// a value, such as prune, are rewritten as prune=true. Quoted and
+// escaped text is left untouched.
+func rewriteComparisons(expr string) string {
+	var out strings.Builder
+	inQuote, atStart := false, true
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '\\' && i+1 < len(expr):
+			out.WriteByte(c)
+			i++
+			out.WriteByte(expr[i])
+			atStart = false
+			continue
+		case c == '\'':
+			inQuote = !inQuote
+		case !inQuote && atStart:
+			if name := comparisonAt(expr[i:]); len(name) > 0 {
+				out.WriteString(name)
+				out.WriteByte('=')
+				i += len(name) - 1
+				atStart = false
+				continue
+			}
+			if name := bareAt(expr[i:]); len(name) > 0 {
+				out.WriteString(name)
+				out.WriteString("=true")
+				i += len(name) - 1
+				atStart = false
+				continue
+			}
+		}
+		// Operands may follow white space, (, ! or either of the && and
+		// || operators, eg. a&&mtime<7d.
+		isOp := (c == '&' || c == '|') && i > 0 && expr[i-1] == c
+		atStart = !inQuote && (isOp || strings.IndexByte(" \t(!", c) >= 0)
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+func comparisonAt(text string) string {
+	for _, name := range comparisonOperands {
+		if strings.HasPrefix(text, name) && len(text) > len(name) {
+			if c := text[len(name)]; c == '<' || c == '>' {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func bareAt(text string) string {
+	for _, name := range bareOperands {
+		if !strings.HasPrefix(text, name) {
+			continue
+		}
+		if len(text) == len(name) || strings.IndexByte(" \t)&|", text[len(name)]) >= 0 {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRewriteComparisons(t *testing.T) {
+	for _, tc := range []struct {
+		input, output string
+	}{
+		{"mtime<7d", "mtime=<7d"},
+		{"mtime>=7d && (atime<=1h || !ctime>2d)", "mtime=>=7d && (atime=<=1h || !ctime=>2d)"},
+		{"mtime=<7d", "mtime=<7d"},
+		{"size>10MiB && entries<=5 || depth<3 && nlink>1", "size=>10MiB && entries=<=5 || depth=<3 && nlink=>1"},
+		{"file-larger=10", "file-larger=10"},
+		{"a&&mtime<7d", "a&&mtime=<7d"},
+		{"a||size>1M&&!depth<2", "a||size=>1M&&!depth=<2"},
+		{"re=a&mtime<7d", "re=a&mtime<7d"},
+		{"name=xmtime<7d", "name=xmtime<7d"},
+		{"re='a mtime<7d'", "re='a mtime<7d'"},
+		{`re=a\ mtime<7d`, `re=a\ mtime<7d`},
+		{"prune", "prune=true"},
+		{"(name=a && prune) || name=b", "(name=a && prune=true) || name=b"},
+		{"name=a && prune&&name=b", "name=a && prune=true&&name=b"},
+		{"name=prune || prunes=x", "name=prune || prunes=x"},
+	} {
+		if got, want := rewriteComparisons(tc.input), tc.output; got != want {
+			t.Errorf("%v: got %v, want %v", tc.input, got, want)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sync"
+
+	"cloudeng.io/cmdutil/boolexpr"
+	"cloudeng.io/file"
+)
+
+// contentProber must be implemented by any values that are used with
+// the contains and contains-fixed operands. Such values carry the results
+// of the content operands for a file, either as determined by reading
+// the file or as a candidate set of results used to determine whether
+// the result of evaluating an expression depends on the contents of the
+// file without reading it.
+type contentProber interface {
+	probeContent(index int) (result, known bool)
+}
+
+// maxContentOperands is the maximum number of content operands that
+// may be used in a single expression.
+const maxContentOperands = 64
+
+// fileContent represents the results of the content operands for a
+// single file, the result of the i'th content operand is given by the
+// i'th bit of mask.
+type fileContent struct {
+	mask uint64
+}
+
+func (fc *fileContent) probeResult(index int) (bool, bool) {
+	if fc == nil {
+		return false, false
+	}
+	return fc.mask&(1<<uint(index)) != 0, true
+}
+
+// contentOperand matches the contents of a file against a regular
+// expression or fixed string. Each content operand in an expression
+// has a unique index that is used when probing.
+type contentOperand struct {
+	name, text, document string
+	index                int
+	fixed                bool
+	re                   *regexp.Regexp
+}
+
+const (
+	containsDoc      = `=<regexp> matches regular files whose contents match the regular expression, files are only read when the rest of the expression cannot determine the result`
+	containsFixedDoc = `=<string> matches regular files whose contents contain the string, files are only read when the rest of the expression cannot determine the result`
+)
+
+func newContentOperand(name, value string, index int, fixed bool) boolexpr.Operand {
+	doc := containsDoc
+	if fixed {
+		doc = containsFixedDoc
+	}
+	return contentOperand{
+		name:     name,
+		text:     value,
+		index:    index,
+		fixed:    fixed,
+		document: name + doc,
+	}
+}
+
+func (op contentOperand) Prepare() (boolexpr.Operand, error) {
+	if len(op.text) == 0 {
+		return op, fmt.Errorf("%v: missing pattern", op.name)
+	}
+	if op.fixed {
+		return op, nil
+	}
+	re, err := regexp.Compile(op.text)
+	if err != nil {
+		return op, err
+	}
+	op.re = re
+	return op, nil
+}
+
+// Eval returns the result of the operand as determined by reading the
+// file, see contentEvaluator, since the contents of files are matched
+// as they are read rather than being retained.
+func (op contentOperand) Eval(v any) bool {
+	if p, ok := v.(contentProber); ok {
+		if result, known := p.probeContent(op.index); known {
+			return result
+		}
+	}
+	return false
+}
+
+// stream returns a contentStream that matches the operand against the
+// contents of a file as they are read.
+func (op contentOperand) stream() contentStream {
+	if op.fixed {
+		k := len(op.text) - 1
+		return &fixedStream{text: []byte(op.text), tail: make([]byte, 0, 2*k)}
+	}
+	return newRegexpStream(op.re)
+}
+
+func (op contentOperand) Needs(t reflect.Type) bool {
+	return t.Implements(reflect.TypeOf((*contentProber)(nil)).Elem())
+}
+
+func (op contentOperand) Document() string {
+	return op.document
+}
+
+func (op contentOperand) String() string {
+	return op.name + "=" + op.text
+}
+
+// prepareContentOperands prepares the supplied content operands so that
+// they may be used to match the contents of files as they are read.
+func prepareContentOperands(ops []contentOperand) ([]contentOperand, error) {
+	if len(ops) > maxContentOperands {
+		return nil, fmt.Errorf("at most %v contains and contains-fixed operands may be used", maxContentOperands)
+	}
+	prepared := make([]contentOperand, len(ops))
+	for i, op := range ops {
+		p, err := op.Prepare()
+		if err != nil {
+			return nil, err
+		}
+		prepared[i] = p.(contentOperand)
+	}
+	return prepared, nil
+}
+
+// registerContentOperands registers the contains and contains-fixed
+// operands and returns a function that returns the operands created,
+// in order of their index.
+func registerContentOperands(parser *boolexpr.Parser) func() []contentOperand {
+	var ops []contentOperand
+	parser.RegisterOperand("contains", func(name, v string) boolexpr.Operand {
+		op := newContentOperand(name, v, len(ops), false)
+		ops = append(ops, op.(contentOperand))
+		return op
+	})
+	parser.RegisterOperand("contains-fixed", func(name, v string) boolexpr.Operand {
+		op := newContentOperand(name, v, len(ops), true)
+		ops = append(ops, op.(contentOperand))
+		return op
+	})
+	return func() []contentOperand { return ops }
+}
+
+// maxContentProbes is the maximum number of content operands for which
+// all combinations of their results will be tried in order to avoid
+// reading a file.
+const maxContentProbes = 8
+
+// evalWithoutContent determines if the result of evaluating the
+// expression, including whether the value is to be pruned, is the same
+// regardless of the results of the content operands, in which case the
+// contents of the file need not be read.
+func (e expression) evalWithoutContent(v contentValue) (result, pruned, decided bool) {
+	if len(e.content) > maxContentProbes {
+		return false, false, false
+	}
+	result, pruned = e.evalPrune(v.withContent(&fileContent{}))
+	for mask := uint64(1); mask < 1<<uint(len(e.content)); mask++ {
+		r, p := e.evalPrune(v.withContent(&fileContent{mask: mask}))
+		if r != result || p != pruned {
+			return false, false, false
+		}
+	}
+	return result, pruned, true
+}
+
+// contentValue is implemented by the values that the expression is
+// evaluated against to allow for their contents to be supplied.
+type contentValue interface {
+	Path() string
+	isRegular() bool
+	withContent(*fileContent) any
+}
+
+// contentEvaluator reads the contents of files, subject to a limit on
+// the number of files that may be read concurrently across all of
+// the directories being scanned. Files are read using a fixed size
+// buffer and matched as they are read, rather than being read in
+// their entirety.
+type contentEvaluator struct {
+	fs       file.FS
+	sem      chan struct{}
+	maxBytes int64
+	binary   bool
+	// pending tracks the files being read asynchronously.
+	pending sync.WaitGroup
+}
+
+func newContentEvaluator(fs file.FS, readers int, maxBytes int64, binary bool) *contentEvaluator {
+	if readers <= 0 {
+		readers = 1
+	}
+	return &contentEvaluator{
+		fs:       fs,
+		sem:      make(chan struct{}, readers),
+		maxBytes: maxBytes,
+		binary:   binary,
+	}
+}
+
+// binaryPrefix is the number of bytes examined to determine if a file
+// is binary.
+const binaryPrefix = 8192
+
+// contentBufferSize is the size of the buffer used to read each file.
+const contentBufferSize = 64 * 1024
+
+// contentStream matches a content operand against the contents of a
+// file as they are read.
+type contentStream interface {
+	// write supplies the next part of the file and returns true if the
+	// operand is known to match.
+	write(p []byte) bool
+	// close is called once the file has been read and returns true if
+	// the operand matched.
+	close() bool
+}
+
+// fixedStream matches a fixed string, retaining the tail of the previous
+// write to detect matches that span writes.
+type fixedStream struct {
+	text, tail []byte
+	matched    bool
+}
+
+func (s *fixedStream) write(p []byte) bool {
+	if s.matched {
+		return true
+	}
+	k := len(s.text) - 1
+	if len(s.tail) > 0 && bytes.Contains(append(s.tail, p[:min(len(p), k)]...), s.text) {
+		s.matched = true
+		return true
+	}
+	if bytes.Contains(p, s.text) {
+		s.matched = true
+		return true
+	}
+	if len(p) >= k {
+		s.tail = append(s.tail[:0], p[len(p)-k:]...)
+	} else {
+		s.tail = append(s.tail, p...)
+		s.tail = s.tail[len(s.tail)-min(len(s.tail), k):]
+	}
+	return false
+}
+
+func (s *fixedStream) close() bool {
+	return s.matched
+}
+
+// regexpStream matches a regular expression using regexp.MatchReader,
+// which reads its input incrementally, in a goroutine that the contents
+// of the file are written to via a pipe.
+type regexpStream struct {
+	pw      *io.PipeWriter
+	done    chan bool
+	matched bool
+	closed  bool
+}
+
+func newRegexpStream(re *regexp.Regexp) *regexpStream {
+	pr, pw := io.Pipe()
+	s := &regexpStream{pw: pw, done: make(chan bool, 1)}
+	go func() {
+		matched := re.MatchReader(bufio.NewReader(pr))
+		// Any further writes fail once a match is found.
+		pr.Close()
+		s.done <- matched
+	}()
+	return s
+}
+
+func (s *regexpStream) write(p []byte) bool {
+	if s.closed {
+		return s.matched
+	}
+	if _, err := s.pw.Write(p); err != nil {
+		return s.close()
+	}
+	return false
+}
+
+func (s *regexpStream) close() bool {
+	if !s.closed {
+		s.pw.Close()
+		s.matched = <-s.done
+		s.closed = true
+	}
+	return s.matched
+}
+
+// read reads a file, up to the configured limit, and returns the results
+// of matching each of the supplied operands against its contents.
+func (ce *contentEvaluator) read(ctx context.Context, path string, ops []contentOperand) (*fileContent, error) {
+	f, err := ce.fs.OpenCtx(ctx, path)
+	if err != nil {
+		return &fileContent{}, err
+	}
+	defer f.Close()
+	var rd io.Reader = f
+	if ce.maxBytes > 0 {
+		rd = io.LimitReader(f, ce.maxBytes)
+	}
+	buf := make([]byte, contentBufferSize)
+	streams := make([]contentStream, len(ops))
+	for i, op := range ops {
+		streams[i] = op.stream()
+	}
+	fc := &fileContent{}
+	defer func() {
+		for i, s := range streams {
+			if s.close() {
+				fc.mask |= 1 << uint(i)
+			}
+		}
+	}()
+	for first := true; ; first = false {
+		n, err := io.ReadFull(rd, buf)
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return &fileContent{}, err
+		}
+		if first && !ce.binary && bytes.IndexByte(buf[:min(n, binaryPrefix)], 0) >= 0 {
+			return &fileContent{}, nil
+		}
+		all := true
+		for _, s := range streams {
+			if !s.write(buf[:n]) {
+				all = false
+			}
+		}
+		if all || eof {
+			return fc, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return &fileContent{}, err
+		}
+	}
+}
+
+// start runs fn once a reader is available, or returns false if the
+// context is canceled first.
+func (ce *contentEvaluator) start(ctx context.Context, wg *sync.WaitGroup, fn func()) bool {
+	select {
+	case ce.sem <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	wg.Add(1)
+	go func() {
+		defer func() {
+			<-ce.sem
+			wg.Done()
+		}()
+		fn()
+	}()
+	return true
+}
+
+// wait waits for all of the files being read asynchronously, see
+// evalValues, to be read and evaluated.
+func (ce *contentEvaluator) wait() {
+	if ce != nil {
+		ce.pending.Wait()
+	}
+}
+
+// evalValues evaluates the expression against each of the supplied
+// values and determines which of them are to be pruned. If a content
+// evaluator is supplied then the contents of files are read,
+// concurrently, but only for those files for which the result depends
+// on their contents. If matchedLater is nil, evalValues waits for all
+// such files to be read, otherwise they are evaluated asynchronously,
+// and matchedLater is called for each one that matches, so that reading
+// files does not delay scanning directories. Such values are regular
+// files and hence are never pruned. Any errors encountered reading files
+// are reported via the supplied function.
+func evalValues[T contentValue](ctx context.Context, expr expression, ce *contentEvaluator, vals []T, report func(path string, err error), matchedLater func(i int)) (matched, pruned []bool) {
+	matched = make([]bool, len(vals))
+	pruned = make([]bool, len(vals))
+	if ce == nil {
+		for i, v := range vals {
+			matched[i], pruned[i] = expr.evalPrune(v)
+		}
+		return matched, pruned
+	}
+	var wg sync.WaitGroup
+	for i, v := range vals {
+		if result, prune, decided := expr.evalWithoutContent(v); decided {
+			matched[i], pruned[i] = result, prune
+			continue
+		}
+		if !v.isRegular() {
+			matched[i], pruned[i] = expr.evalPrune(v)
+			continue
+		}
+		i, v := i, v
+		eval := func() bool {
+			fc, err := ce.read(ctx, v.Path(), expr.content)
+			if err != nil {
+				report(v.Path(), err)
+			}
+			m, _ := expr.evalPrune(v.withContent(fc))
+			return m
+		}
+		if matchedLater != nil {
+			ce.start(ctx, &ce.pending, func() {
+				if eval() {
+					matchedLater(i)
+				}
+			})
+			continue
+		}
+		ce.start(ctx, &wg, func() {
+			matched[i] = eval()
+		})
+	}
+	wg.Wait()
+	return matched, pruned
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/localfs"
+)
+
+func TestContentProbes(t *testing.T) {
+	goFile := entryType{name: "a.go", path: "a.go"}
+	txtFile := entryType{name: "a.txt", path: "a.txt"}
+	for _, tc := range []struct {
+		expr            string
+		value           entryType
+		result, decided bool
+	}{
+		{"name=*.go && contains=TODO", txtFile, false, true},
+		{"name=*.go && contains=TODO", goFile, false, false},
+		{"name=*.go || contains=TODO", goFile, true, true},
+		{"name=*.go || contains=TODO", txtFile, false, false},
+		{"contains=a && !contains-fixed=b", goFile, false, false},
+		{"name=*.txt && (contains=a || !contains-fixed=b)", goFile, false, true},
+		{"name=*.go", goFile, true, true},
+	} {
+		expr := newExpr(t, tc.expr)
+		result, _, decided := expr.evalWithoutContent(tc.value)
+		if got, want := decided, tc.decided; got != want {
+			t.Errorf("%v: %v: got %v, want %v", tc.expr, tc.value.name, got, want)
+		}
+		if got, want := result, tc.result; decided && got != want {
+			t.Errorf("%v: %v: got %v, want %v", tc.expr, tc.value.name, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		expr  string
+		needs bool
+	}{
+		{"contains=x", true},
+		{"name=x || contains-fixed=y", true},
+		{"name=x", false},
+	} {
+		expr := newExpr(t, tc.expr)
+		if got, want := expr.NeedsContent(), tc.needs; got != want {
+			t.Errorf("%v: got %v, want %v", tc.expr, got, want)
+		}
+		if expr.NeedsStat() {
+			t.Errorf("%v: should not require stat", tc.expr)
+		}
+	}
+}
+
+// openCounter records the files opened via a filewalk.FS.
+type openCounter struct {
+	filewalk.FS
+	mu     sync.Mutex
+	opened []string
+}
+
+func (oc *openCounter) OpenCtx(ctx context.Context, name string) (fs.File, error) {
+	oc.mu.Lock()
+	oc.opened = append(oc.opened, filepath.Base(name))
+	oc.mu.Unlock()
+	return oc.FS.OpenCtx(ctx, name)
+}
+
+func TestContains(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	for name, contents := range map[string]string{
+		"a.go":       "package a\n// TODO: fix\n",
+		"b.go":       "package b\n",
+		"c.txt":      "TODO: not go\n",
+		"d/e.go":     "package e\nfunc TODO() {}\n",
+		"d/f.bin":    "TODO\x00binary",
+		"d/large.go": strings.Repeat("x", 1024) + "TODO",
+	} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(lf *locateFlags, expr string) ([]string, []string) {
+		oc := &openCounter{FS: localfs.New()}
+		var out, errs bytes.Buffer
+		o := newOutput(&out, &errs, textFormat, false)
+		v := visit{ctx: ctx, fs: oc, lf: lf, out: o, roots: []string{tmpDir}}
+		if err := (locateCmd{}).locateFS(ctx, oc, lf, v.visit, []string{tmpDir, expr}); err != nil {
+			t.Fatal(err)
+		}
+		if errs.Len() > 0 {
+			t.Errorf("unexpected errors: %v", errs.String())
+		}
+		var matches []string
+		for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if len(l) > 0 {
+				matches = append(matches, filepath.ToSlash(strings.TrimPrefix(l, tmpDir+string(filepath.Separator))))
+			}
+		}
+		sort.Strings(matches)
+		sort.Strings(oc.opened)
+		return matches, oc.opened
+	}
+
+	for _, sorted := range []bool{false, true} {
+		for _, long := range []bool{false, true} {
+			lf := &locateFlags{Sorted: sorted, Long: long, Depth: -1, ContainsReaders: 2}
+			lf.ScanSize = 2
+
+			matches, opened := run(lf, "name=*.go && contains=TODO")
+			if got, want := strings.Join(matches, ","), "a.go,d/e.go,d/large.go"; !long && got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if got, want := len(matches), 3; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if got, want := strings.Join(opened, ","), "a.go,b.go,e.go,large.go"; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+
+			lf.ContainsMax = "1k"
+			matches, _ = run(lf, "contains-fixed=TODO")
+			if got, want := len(matches), 3; got != want {
+				t.Errorf("got %v, want %v: %v", got, want, matches)
+			}
+
+			lf.ContainsBinary = true
+			matches, _ = run(lf, "contains=^TODO")
+			if got, want := len(matches), 2; got != want {
+				t.Errorf("got %v, want %v: %v", got, want, matches)
+			}
+		}
+	}
+}
+
+func TestContentStreams(t *testing.T) {
+	data := []byte(strings.Repeat("x", 100) + "TODO" + strings.Repeat("y", 100))
+	for _, tc := range []struct {
+		expr    string
+		matched bool
+	}{
+		{"contains-fixed=TODO", true},
+		{"contains-fixed=xTODOy", true},
+		{"contains-fixed=DONE", false},
+		{"contains=x+TODOy", true},
+		{"contains=^x{100}T", true},
+		{"contains=TODO$", false},
+	} {
+		expr := newExpr(t, tc.expr)
+		// Write the contents in chunks of varying sizes so that matches
+		// span chunk boundaries.
+		for _, size := range []int{1, 3, 101, 102, len(data)} {
+			s := expr.content[0].stream()
+			matched := false
+			for i := 0; i < len(data) && !matched; i += size {
+				matched = s.write(data[i:min(i+size, len(data))])
+			}
+			if s.close() {
+				matched = true
+			}
+			if got, want := matched, tc.matched; got != want {
+				t.Errorf("%v: chunk size %v: got %v, want %v", tc.expr, size, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"cloudeng.io/path/cloudpath"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var errNotSearched = errors.New("not deleted since it was not searched: it is excluded, on a different device or beyond the --depth limit")
+
+// remover is implemented by each of the supported file systems
+// to delete files and directories.
+type remover interface {
+	// remove deletes a single file or an empty directory.
+	remove(ctx context.Context, path string, isDir bool) error
+	// removeAll deletes a directory and all of its contents.
+	removeAll(ctx context.Context, path string) error
+	// flush completes any pending deletions.
+	flush(ctx context.Context) error
+}
+
+// deleter implements the --delete action. Files are deleted as they are
+// matched whereas matching directories are deleted only once all of
+// their contents have been walked, ie. in post-order, so that matching
+// files within them have already been deleted. Directories that are
+// never walked, for example because they are excluded or on a different
+// device, are never deleted. For a dry run the files and directories that
+// would be deleted are displayed instead. Failures are reported via
+// report.
+type deleter struct {
+	out       *output
+	report    func(path string, err error)
+	rm        remover
+	dryRun    bool
+	recursive bool
+
+	mu   sync.Mutex
+	dirs map[string]struct{}
+
+	matched, failed int64
+}
+
+// newDeleter creates a new deleter, its remover must be set before
+// it is used.
+func newDeleter(out *output, report func(path string, err error), dryRun, recursive bool) *deleter {
+	return &deleter{
+		out:       out,
+		report:    report,
+		dryRun:    dryRun,
+		recursive: recursive,
+		dirs:      map[string]struct{}{},
+	}
+}
+
+// match is called for every match.
+func (d *deleter) match(ctx context.Context, path string, isDir bool) {
+	if isDir {
+		d.mu.Lock()
+		d.dirs[path] = struct{}{}
+		d.mu.Unlock()
+		return
+	}
+	d.delete(ctx, path, false)
+}
+
+// postDir is called once all of the contents of a directory have been
+// walked.
+func (d *deleter) postDir(ctx context.Context, path string) {
+	d.mu.Lock()
+	_, ok := d.dirs[path]
+	delete(d.dirs, path)
+	d.mu.Unlock()
+	if ok {
+		d.delete(ctx, path, true)
+	}
+}
+
+func (d *deleter) delete(ctx context.Context, path string, isDir bool) {
+	d.mu.Lock()
+	d.matched++
+	d.mu.Unlock()
+	if d.dryRun {
+		d.out.text(path)
+		return
+	}
+	var err error
+	if isDir && d.recursive {
+		err = d.rm.removeAll(ctx, path)
+	} else {
+		err = d.rm.remove(ctx, path, isDir)
+	}
+	if err != nil {
+		d.failure(path, err)
+	}
+}
+
+func (d *deleter) failure(path string, err error) {
+	d.mu.Lock()
+	d.failed++
+	d.mu.Unlock()
+	d.report(path, err)
+}
+
+// finish completes any pending deletions and reports any matching
+// directories that were not deleted because they were not walked.
+func (d *deleter) finish(ctx context.Context) error {
+	if err := d.rm.flush(ctx); err != nil {
+		return err
+	}
+	for path := range d.dirs {
+		d.matched++
+		d.failure(path, errNotSearched)
+	}
+	if d.dryRun {
+		d.out.message("dry run: %v files and directories would be deleted, use --yes to delete them", d.matched)
+	}
+	if d.failed > 0 {
+		return fmt.Errorf("%v out of %v files and directories were not deleted", d.failed, d.matched)
+	}
+	return nil
+}
+
+// schemeRemovers dispatches to the remover for the scheme of each path
+// so that files and directories on different file systems may be
+// deleted by a single deleter.
+type schemeRemovers map[string]remover
+
+func (sr schemeRemovers) remover(path string) (remover, error) {
+	scheme := cloudpath.DefaultMatchers.Match(path).Scheme
+	if rm, ok := sr[scheme]; ok {
+		return rm, nil
+	}
+	return nil, fmt.Errorf("unsupported file system scheme: %v", scheme)
+}
+
+func (sr schemeRemovers) remove(ctx context.Context, path string, isDir bool) error {
+	rm, err := sr.remover(path)
+	if err != nil {
+		return err
+	}
+	return rm.remove(ctx, path, isDir)
+}
+
+func (sr schemeRemovers) removeAll(ctx context.Context, path string) error {
+	rm, err := sr.remover(path)
+	if err != nil {
+		return err
+	}
+	return rm.removeAll(ctx, path)
+}
+
+func (sr schemeRemovers) flush(ctx context.Context) error {
+	var errs []error
+	for _, rm := range sr {
+		errs = append(errs, rm.flush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+type localRemover struct{}
+
+func (localRemover) remove(_ context.Context, path string, _ bool) error {
+	return os.Remove(path)
+}
+
+func (localRemover) removeAll(_ context.Context, path string) error {
+	return os.RemoveAll(path)
+}
+
+func (localRemover) flush(context.Context) error {
+	return nil
+}
+
+// s3Client represents the subset of the S3 API used to delete objects.
+type s3Client interface {
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// maxS3DeleteBatch is the maximum number of keys that can be deleted
+// by a single DeleteObjects call.
+const maxS3DeleteBatch = 1000
+
+// s3Remover deletes objects in batches of up to maxS3DeleteBatch keys
+// per bucket. Directories on S3 are prefixes rather than objects and
+// cease to exist once they contain no objects, hence removing an empty
+// directory requires only checking that it is indeed empty.
+type s3Remover struct {
+	client  s3Client
+	failure func(path string, err error)
+
+	mu      sync.Mutex
+	pending map[string][]string // keys, indexed by bucket
+}
+
+func newS3Remover(client s3Client, failure func(path string, err error)) *s3Remover {
+	return &s3Remover{
+		client:  client,
+		failure: failure,
+		pending: map[string][]string{},
+	}
+}
+
+func s3BucketAndKey(path string) (string, string, error) {
+	match := cloudpath.DefaultMatchers.Match(path)
+	if len(match.Matched) == 0 || match.Scheme != "s3" {
+		return "", "", fmt.Errorf("invalid s3 path: %v", path)
+	}
+	return match.Volume, match.Key, nil
+}
+
+// s3Prefix returns the prefix used to list the contents of the
+// directory represented by key.
+func s3Prefix(key string) string {
+	if len(key) > 0 && !strings.HasSuffix(key, "/") {
+		return key + "/"
+	}
+	return key
+}
+
+func s3Path(bucket, key string) string {
+	return "s3://" + bucket + "/" + key
+}
+
+func (r *s3Remover) remove(ctx context.Context, path string, isDir bool) error {
+	bucket, key, err := s3BucketAndKey(path)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		return r.removeDir(ctx, bucket, key)
+	}
+	r.mu.Lock()
+	r.pending[bucket] = append(r.pending[bucket], key)
+	var keys []string
+	if len(r.pending[bucket]) >= maxS3DeleteBatch {
+		keys = r.pending[bucket]
+		delete(r.pending, bucket)
+	}
+	r.mu.Unlock()
+	r.deleteObjects(ctx, bucket, keys, r.failure)
+	return nil
+}
+
+// removeDir deletes any pending objects within the directory and
+// then checks that it is empty.
+func (r *s3Remover) removeDir(ctx context.Context, bucket, key string) error {
+	r.flush(ctx) //nolint:errcheck
+	prefix := s3Prefix(key)
+	objs, err := r.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return err
+	}
+	if len(objs.Contents) > 0 {
+		return fmt.Errorf("directory not empty")
+	}
+	return nil
+}
+
+func (r *s3Remover) removeAll(ctx context.Context, path string) error {
+	bucket, key, err := s3BucketAndKey(path)
+	if err != nil {
+		return err
+	}
+	prefix := s3Prefix(key)
+	var token *string
+	var errs []error
+	report := func(path string, err error) {
+		errs = append(errs, fmt.Errorf("%v: %v", path, err))
+	}
+	for {
+		objs, err := r.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            aws.String(bucket),
+			Prefix:            aws.String(prefix),
+			ContinuationToken: token,
+		})
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(objs.Contents))
+		for _, obj := range objs.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+		r.deleteObjects(ctx, bucket, keys, report)
+		if !aws.ToBool(objs.IsTruncated) {
+			return errors.Join(errs...)
+		}
+		token = objs.NextContinuationToken
+	}
+}
+
+// deleteObjects deletes the supplied keys, reporting the failure to
+// delete any one of them via the supplied function.
+func (r *s3Remover) deleteObjects(ctx context.Context, bucket string, keys []string, report func(path string, err error)) {
+	if len(keys) == 0 {
+		return
+	}
+	ids := make([]types.ObjectIdentifier, len(keys))
+	for i, k := range keys {
+		ids[i] = types.ObjectIdentifier{Key: aws.String(k)}
+	}
+	res, err := r.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		for _, k := range keys {
+			report(s3Path(bucket, k), err)
+		}
+		return
+	}
+	for _, e := range res.Errors {
+		report(s3Path(bucket, aws.ToString(e.Key)),
+			fmt.Errorf("%v: %v", aws.ToString(e.Code), aws.ToString(e.Message)))
+	}
+}
+
+func (r *s3Remover) flush(ctx context.Context) error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = map[string][]string{}
+	r.mu.Unlock()
+	for bucket, keys := range pending {
+		r.deleteObjects(ctx, bucket, keys, r.failure)
+	}
+	return nil
+}
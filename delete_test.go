@@ -0,0 +1,225 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/filewalktestutil"
+	"cloudeng.io/file/localfs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func runDelete(ctx context.Context, t *testing.T, fs filewalk.FS, lf *locateFlags, args ...string) (stdout, stderr string, err error) {
+	var out, errs bytes.Buffer
+	o := newOutput(&out, &errs, textFormat, false)
+	v := visit{ctx: ctx, fs: fs, lf: lf, out: o, roots: args[:1]}
+	v.delete = newDeleter(o, v.error, !lf.Yes, lf.Recursive)
+	v.delete.rm = localRemover{}
+	opts := append([]walkerOption{flagExclusions(t, lf)}, v.options()...)
+	if err := (locateCmd{}).locateFS(ctx, fs, lf, v.visit, args, opts...); err != nil {
+		t.Fatal(err)
+	}
+	err = v.finish(nil)
+	return out.String(), errs.String(), err
+}
+
+func TestDeleteDryRun(t *testing.T) {
+	ctx := context.Background()
+	fs, err := filewalktestutil.NewMockFS("r", filewalktestutil.WithYAMLConfig(withSizesSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sorted := range []bool{false, true} {
+		lf := &locateFlags{Sorted: sorted, Depth: -1, Delete: true}
+		lf.ScanSize = 100
+		out, errs, err := runDelete(ctx, t, fs, lf, "r")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		idx := map[string]int{}
+		for i, l := range lines {
+			idx[l] = i
+		}
+		if idx["r/d0/f1"] > idx["r/d0"] {
+			t.Errorf("directory listed before its contents: %v", lines)
+		}
+		if sorted {
+			if got, want := strings.Join(lines, ","), "r/f0,r/d0/f1,r/d0,r"; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+		sort.Strings(lines)
+		if got, want := strings.Join(lines, ","), "r,r/d0,r/d0/f1,r/f0"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if !strings.Contains(errs, "4 files and directories would be deleted") {
+			t.Errorf("missing or incorrect dry run message: %v", errs)
+		}
+	}
+}
+
+func createDeleteTree(t *testing.T) string {
+	tmpDir := t.TempDir()
+	for _, d := range []string{"a", "b", "c", "x/y"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, d), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"a/f.txt", "a/f.log", "b/f.txt", "x/y/f.log", "x/f.txt"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, f), []byte(f), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tmpDir
+}
+
+func listTree(t *testing.T, dir string) string {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir {
+			paths = append(paths, filepath.ToSlash(strings.TrimPrefix(path, dir+string(filepath.Separator))))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, ",")
+}
+
+func TestDeleteLocal(t *testing.T) {
+	ctx := context.Background()
+	fs := localfs.New()
+	for _, sorted := range []bool{false, true} {
+		tmpDir := createDeleteTree(t)
+		lf := &locateFlags{Sorted: sorted, Depth: -1, Delete: true, Yes: true}
+		lf.ScanSize = 100
+
+		_, errs, err := runDelete(ctx, t, fs, lf, tmpDir, "name=*.txt || name=a || name=b || name=c")
+		if err == nil || err.Error() != "1 out of 6 files and directories were not deleted" {
+			t.Errorf("unexpected or missing error: %v", err)
+		}
+		if !strings.Contains(errs, filepath.Join(tmpDir, "a")) {
+			t.Errorf("missing error for non-empty directory: %v", errs)
+		}
+		if got, want := listTree(t, tmpDir), "a,a/f.log,x,x/y,x/y/f.log"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		lf.Recursive = true
+		lf.Exclusions.Values = []string{"/y$"}
+		_, errs, err = runDelete(ctx, t, fs, lf, tmpDir, "name=a || name=y")
+		if err == nil || err.Error() != "1 out of 2 files and directories were not deleted" {
+			t.Errorf("unexpected or missing error: %v", err)
+		}
+		if !strings.Contains(errs, errNotSearched.Error()) {
+			t.Errorf("missing error for excluded directory: %v", errs)
+		}
+		if got, want := listTree(t, tmpDir), "x,x/y,x/y/f.log"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+type mockS3Client struct {
+	sync.Mutex
+	deleted [][]string
+	objects []string
+}
+
+func (c *mockS3Client) DeleteObjects(_ context.Context, params *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+	var keys []string
+	var errs []types.Error
+	for _, o := range params.Delete.Objects {
+		key := aws.ToString(o.Key)
+		if strings.HasSuffix(key, "denied") {
+			errs = append(errs, types.Error{Key: o.Key, Code: aws.String("AccessDenied"), Message: aws.String("denied")})
+			continue
+		}
+		keys = append(keys, aws.ToString(params.Bucket)+"/"+key)
+	}
+	c.deleted = append(c.deleted, keys)
+	return &s3.DeleteObjectsOutput{Errors: errs}, nil
+}
+
+func (c *mockS3Client) ListObjectsV2(_ context.Context, params *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.Lock()
+	defer c.Unlock()
+	var objs []types.Object
+	for _, o := range c.objects {
+		if strings.HasPrefix(o, aws.ToString(params.Prefix)) {
+			objs = append(objs, types.Object{Key: aws.String(o)})
+		}
+	}
+	return &s3.ListObjectsV2Output{Contents: objs, IsTruncated: aws.Bool(false)}, nil
+}
+
+func TestS3Delete(t *testing.T) {
+	ctx := context.Background()
+	client := &mockS3Client{}
+	var failures []string
+	rm := newS3Remover(client, func(path string, err error) {
+		failures = append(failures, fmt.Sprintf("%v: %v", path, err))
+	})
+	for i := 0; i < 2500; i++ {
+		if err := rm.remove(ctx, fmt.Sprintf("s3://bucket/dir/f%04d", i), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rm.remove(ctx, "s3://bucket/dir/denied", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(client.deleted), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, n := range []int{1000, 1000, 500} {
+		if got, want := len(client.deleted[i]), n; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got, want := client.deleted[0][0], "bucket/dir/f0000"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := strings.Join(failures, ","), "s3://bucket/dir/denied: AccessDenied: denied"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	client.objects = []string{"dir/a", "dir/b", "other/c"}
+	if err := rm.remove(ctx, "s3://bucket/dir", true); err == nil || err.Error() != "directory not empty" {
+		t.Errorf("unexpected or missing error: %v", err)
+	}
+	if err := rm.remove(ctx, "s3://bucket/empty", true); err != nil {
+		t.Error(err)
+	}
+	client.deleted = nil
+	if err := rm.removeAll(ctx, "s3://bucket/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprintf("%v", client.deleted), "[[bucket/dir/a bucket/dir/b]]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
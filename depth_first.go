@@ -9,28 +9,33 @@ import (
 
 	"cloudeng.io/file"
 	"cloudeng.io/file/filewalk"
-	"cloudeng.io/file/filewalk/asyncstat"
 )
 
 type depthFirst struct {
 	expr  expression
-	stats *asyncstat.T
+	stats statProcessor
 	fs    filewalk.FS
 	visit visitor
 	walkerOptions
+	lookahead *lookahead
+	walkStats *walkStats
 }
 
-func newDepthFirstWalker(expr expression, fs filewalk.FS, stats *asyncstat.T, walkerOpts []walkerOption, visit visitor) *depthFirst {
+func newDepthFirstWalker(expr expression, fs filewalk.FS, stats statProcessor, walkerOpts []walkerOption, visit visitor) *depthFirst {
 	w := &depthFirst{
-		expr:  expr,
-		fs:    fs,
-		stats: stats,
-		visit: visit,
+		expr:      expr,
+		fs:        fs,
+		stats:     stats,
+		visit:     visit,
+		walkStats: statsFor(fs),
 	}
 	w.depth = -1
 	for _, opt := range walkerOpts {
 		opt(&w.walkerOptions)
 	}
+	if w.lookaheadDirs > 0 && !w.exactNumEntries {
+		w.lookahead = newLookahead(fs, w.scanSize, w.lookaheadDirs)
+	}
 	return w
 }
 
@@ -43,22 +48,22 @@ func (d *depthFirst) start(ctx context.Context, start string) error {
 	if err != nil {
 		return err
 	}
-	if !info.IsDir() {
-		entry := filewalk.Entry{
-			Name: d.fs.Base(start),
-			Type: info.Mode(),
-		}
-		d.visit(start, "", entry, &info, nil)
+	pruned := d.matchRoot(ctx, d.expr, d.fs, start, info, d.visit)
+	if !info.IsDir() || pruned {
 		return nil
 	}
-	return d.handleDir(ctx, start, 0, info)
+	return d.handleDir(ctx, start, 0, info, nil)
 }
 
-func (d *depthFirst) handleDir(ctx context.Context, dirName string, depth int, dirInfo file.Info) error {
+func (d *depthFirst) handleDir(ctx context.Context, dirName string, depth int, dirInfo file.Info, ignore *ignoreRules) error {
+	prefetched := d.lookahead.take(dirName)
+	if prefetched != nil {
+		defer prefetched.cancel()
+	}
 	if d.depth >= 0 && depth > d.depth {
 		return nil
 	}
-	if d.exclude.Match(dirName) {
+	if d.exclude.Match(dirName, depth) {
 		return nil
 	}
 	same, err := d.isSameDevice.Match(ctx, d.fs, dirName, dirInfo)
@@ -69,6 +74,11 @@ func (d *depthFirst) handleDir(ctx context.Context, dirName string, depth int, d
 	if !same {
 		return nil
 	}
+	if d.gitignore != nil {
+		if ignore, err = d.gitignore.load(ctx, ignore, dirName); err != nil {
+			d.visit(dirName, "", filewalk.Entry{}, nil, err)
+		}
+	}
 	ws := withStat{
 		ctx:        ctx,
 		name:       dirInfo.Name(),
@@ -76,27 +86,64 @@ func (d *depthFirst) handleDir(ctx context.Context, dirName string, depth int, d
 		fs:         d.fs,
 		info:       dirInfo,
 		numEntries: 0, // num entries is zero now.
+		depth:      depth,
 	}
-	sc := d.fs.LevelScanner(ws.path)
 	numEntries := int64(0)
-	for sc.Scan(ctx, d.scanSize) {
-		contents := sc.Contents()
-		numEntries += int64(len(contents))
-		if err := d.handleContents(ctx, dirName, depth+1, contents, numEntries); err != nil {
+	process := func(contents []filewalk.Entry) {
+		if !d.exactNumEntries {
+			numEntries += int64(len(contents))
+		}
+		if d.gitignore != nil {
+			contents = d.gitignore.filter(ignore, dirName, contents)
+		}
+		if err := d.handleContents(ctx, dirName, depth+1, contents, numEntries, ignore); err != nil {
 			d.visit(dirName, "", filewalk.Entry{}, nil, err)
 		}
 	}
-	return sc.Err()
+	if d.exactNumEntries {
+		n, buffered, complete, err := countEntries(ctx, d.fs, ws.path, d.scanSize, d.maxBuffered)
+		if err != nil {
+			return err
+		}
+		numEntries = n
+		if complete {
+			process(buffered)
+			if d.dirEntries != nil {
+				d.dirEntries(dirName, numEntries)
+			}
+			if d.postDir != nil {
+				d.postDir(ctx, dirName)
+			}
+			return nil
+		}
+	}
+	var sc filewalk.LevelScanner = prefetched
+	if prefetched == nil {
+		sc = d.fs.LevelScanner(ws.path)
+	}
+	for sc.Scan(ctx, d.scanSize) {
+		process(sc.Contents())
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if d.dirEntries != nil {
+		d.dirEntries(dirName, numEntries)
+	}
+	if d.postDir != nil {
+		d.postDir(ctx, dirName)
+	}
+	return nil
 }
 
-func (d *depthFirst) handleContents(ctx context.Context, parent string, depth int, contents []filewalk.Entry, numEntries int64) error {
+func (d *depthFirst) handleContents(ctx context.Context, parent string, depth int, contents []filewalk.Entry, numEntries int64, ignore *ignoreRules) error {
 	if d.needsStat {
-		return d.handleContentsWithStat(ctx, parent, depth, contents, numEntries)
+		return d.handleContentsWithStat(ctx, parent, depth, contents, numEntries, ignore)
 	}
-	return d.handleContentsWithoutStat(ctx, parent, depth, contents, numEntries)
+	return d.handleContentsWithoutStat(ctx, parent, depth, contents, numEntries, ignore)
 }
 
-func (d *depthFirst) handleContentsWithoutStat(ctx context.Context, parent string, depth int, contents []filewalk.Entry, numEntries int64) error {
+func (d *depthFirst) handleContentsWithoutStat(ctx context.Context, parent string, depth int, contents []filewalk.Entry, numEntries int64, ignore *ignoreRules) error {
 	dirs := make([]filewalk.Entry, 0, len(contents))
 	for _, c := range contents {
 		if c.IsDir() {
@@ -113,18 +160,36 @@ func (d *depthFirst) handleContentsWithoutStat(ctx context.Context, parent strin
 	for _, de := range dirEntries {
 		dirMap[de.Name()] = de
 	}
-	for _, c := range contents {
-		wn := entryType{
+	vals := make([]entryType, len(contents))
+	for i, c := range contents {
+		vals[i] = entryType{
 			name:       c.Name,
 			path:       d.fs.Join(parent, c.Name),
 			mode:       c.Type,
 			numEntries: numEntries,
+			depth:      depth,
 		}
-		if d.expr.Eval(wn) {
+	}
+	matches, pruned := evalContents(ctx, d, depth, vals)
+	subdirs := make([]string, 0, len(dirs))
+	for i, c := range contents {
+		if c.IsDir() && !pruned[i] {
+			subdirs = append(subdirs, vals[i].path)
+		}
+	}
+	d.walkStats.queue(len(subdirs))
+	next := d.prefetch(ctx, depth, subdirs, 0)
+	for i, c := range contents {
+		if matches[i] {
 			d.visit(parent, c.Name, c, nil, nil)
 		}
-		if c.IsDir() {
-			if err := d.handleDir(ctx, wn.path, depth, dirMap[c.Name]); err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.IsDir() && !pruned[i] {
+			next = d.prefetch(ctx, depth, subdirs, next)
+			d.walkStats.queue(-1)
+			if err := d.handleDir(ctx, vals[i].path, depth, dirMap[c.Name], ignore); err != nil {
 				d.visit(d.fs.Join(parent, c.Name), "", filewalk.Entry{}, nil, err)
 			}
 		}
@@ -132,27 +197,45 @@ func (d *depthFirst) handleContentsWithoutStat(ctx context.Context, parent strin
 	return nil
 }
 
-func (d *depthFirst) handleContentsWithStat(ctx context.Context, parent string, depth int, contents []filewalk.Entry, numEntries int64) error {
+func (d *depthFirst) handleContentsWithStat(ctx context.Context, parent string, depth int, contents []filewalk.Entry, numEntries int64, ignore *ignoreRules) error {
 	_, all, err := d.stats.Process(ctx, parent, contents)
 	if err != nil {
 		// the only non-nil error will be a context cancellation.
 		return err
 	}
+	vals := make([]withStat, len(all))
 	for i, c := range all {
-		info := c
-		ws := withStat{
+		vals[i] = withStat{
 			ctx:        ctx,
 			name:       c.Name(),
 			path:       d.fs.Join(parent, c.Name()),
 			fs:         d.fs,
 			info:       c,
 			numEntries: numEntries,
+			depth:      depth,
+		}
+	}
+	matches, pruned := evalContents(ctx, d, depth, vals)
+	var subdirs []string
+	for i, c := range all {
+		if c.IsDir() && !pruned[i] {
+			subdirs = append(subdirs, vals[i].path)
 		}
-		if d.expr.Eval(ws) {
+	}
+	d.walkStats.queue(len(subdirs))
+	next := d.prefetch(ctx, depth, subdirs, 0)
+	for i, c := range all {
+		info := c
+		if matches[i] {
 			d.visit(parent, c.Name(), contents[i], &info, nil)
 		}
-		if c.IsDir() {
-			if err := d.handleDir(ctx, d.fs.Join(parent, info.Name()), depth, info); err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.IsDir() && !pruned[i] {
+			next = d.prefetch(ctx, depth, subdirs, next)
+			d.walkStats.queue(-1)
+			if err := d.handleDir(ctx, d.fs.Join(parent, info.Name()), depth, info, ignore); err != nil {
 				d.visit(d.fs.Join(parent, c.Name()), "", filewalk.Entry{}, nil, err)
 				continue
 			}
@@ -160,3 +243,36 @@ func (d *depthFirst) handleContentsWithStat(ctx context.Context, parent string,
 	}
 	return nil
 }
+
+// prefetch starts scanning the subdirectories that are about to be
+// walked, beginning with subdirs[next], ahead of handleDir being called
+// for them and returns the index of the first one that has yet to be
+// started. Subdirectories that are then excluded, or are on a different
+// device, are scanned needlessly but only until handleDir cancels them.
+func (d *depthFirst) prefetch(ctx context.Context, depth int, subdirs []string, next int) int {
+	if d.lookahead == nil || (d.depth >= 0 && depth > d.depth) {
+		return len(subdirs)
+	}
+	return d.lookahead.start(ctx, subdirs, next)
+}
+
+// evalContents evaluates the expression against the entries at the
+// specified depth, matches are only returned if they are reportable and
+// included.
+func evalContents[T contentValue](ctx context.Context, d *depthFirst, depth int, vals []T) (matches, pruned []bool) {
+	if !d.evaluate(d.expr, depth) {
+		return make([]bool, len(vals)), make([]bool, len(vals))
+	}
+	matches, pruned = evalValues(ctx, d.expr, d.content, vals, d.reportError, nil)
+	if !d.reportable(depth) {
+		return make([]bool, len(vals)), pruned
+	}
+	for i, v := range vals {
+		matches[i] = matches[i] && d.exclude.Included(v.Path(), depth)
+	}
+	return matches, pruned
+}
+
+func (d *depthFirst) reportError(path string, err error) {
+	d.visit(path, "", filewalk.Entry{}, nil, err)
+}
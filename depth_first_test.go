@@ -10,6 +10,7 @@ import (
 	"path"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 
@@ -59,7 +60,7 @@ func TestDepthFirst(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	expected := []found{}
+	expected := []found{{"root", "", nil}}
 	for _, o := range ordered {
 		expected = append(expected, found{path.Dir(o), path.Base(o), nil})
 	}
@@ -114,8 +115,8 @@ func TestSameDevice(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	all := []string{"r/f0", "r/f1", "r/d0", "r/d0/f3", "r/d0/f4"}
-	sameDevice := []string{"r/f0", "r/f1", "r/d0"}
+	all := []string{"r", "r/f0", "r/f1", "r/d0", "r/d0/f3", "r/d0/f4"}
+	sameDevice := []string{"r", "r/f0", "r/f1", "r/d0"}
 	for _, tc := range []struct {
 		sorted, sameDevice bool
 		expected           []string
@@ -158,7 +159,7 @@ func TestExclusions(t *testing.T) {
 	re := regexp.MustCompile(exclusions.String())
 
 	excluded := 0
-	expected := []found{}
+	expected := []found{{"root", "", nil}}
 	for _, o := range ordered {
 		if re.MatchString(path.Dir(o)) {
 			excluded++
@@ -175,7 +176,7 @@ func TestExclusions(t *testing.T) {
 			lf.ScanSize = 100
 			collect := &collector{}
 			lc := locateCmd{}
-			if err := lc.locateFS(ctx, fs, lf, collect.visit, []string{"root"}); err != nil {
+			if err := lc.locateFS(ctx, fs, lf, collect.visit, []string{"root"}, flagExclusions(t, lf)); err != nil {
 				t.Fatal(err)
 			}
 			cmpFoundAnyOrder(t, collect.found, expected)
@@ -185,3 +186,111 @@ func TestExclusions(t *testing.T) {
 		}
 	}
 }
+
+func TestLookahead(t *testing.T) {
+	ctx := context.Background()
+	spec, _ := yamlForMockFS("root", 10, 4, []string{})
+	fs, err := filewalktestutil.NewMockFS("root", filewalktestutil.WithYAMLConfig(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exclusions flags.Repeating
+	if err := exclusions.Set(".*-4-2$"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []locateFlags{
+		{Depth: -1},
+		{Depth: -1, Long: true},
+		{Depth: 2},
+		{Depth: -1, Exclusions: exclusions},
+		{Depth: -1, MinDepth: 3},
+	} {
+		var expected []found
+		for _, lookahead := range []int{0, 1, 3, 100} {
+			lf := tc
+			lf.Sorted, lf.SortedLookahead = true, lookahead
+			lf.ScanSize = 3
+			collect := &collector{}
+			if err := (locateCmd{}).locateFS(ctx, fs, &lf, collect.visit, []string{"root"}, flagExclusions(t, &lf)); err != nil {
+				t.Fatal(err)
+			}
+			if len(collect.errs) > 0 {
+				t.Errorf("lookahead %v: unexpected errors: %v", lookahead, collect.errs)
+			}
+			if lookahead == 0 {
+				expected = collect.found
+				continue
+			}
+			cmpFound(t, collect.found, expected)
+		}
+	}
+}
+
+// withDevicesSpec has two starting locations, r/a and r/b, on different
+// devices each of which has a subdirectory on the device of the other.
+const withDevicesSpec = `
+name: r
+device: 30
+file_id: 40
+entries:
+  - dir:
+	  name: a
+	  device: 30
+	  file_id: 41
+	  entries:
+		- file:
+			name: fa
+			device: 30
+			file_id: 45
+		- dir:
+			name: m
+			device: 40
+			file_id: 42
+			entries:
+			  - file:
+				  name: fm
+				  device: 40
+				  file_id: 46
+  - dir:
+	  name: b
+	  device: 40
+	  file_id: 43
+	  entries:
+		- file:
+			name: fb
+			device: 40
+			file_id: 47
+		- dir:
+			name: n
+			device: 30
+			file_id: 44
+			entries:
+			  - file:
+				  name: fn
+				  device: 30
+				  file_id: 48
+`
+
+func TestSameDeviceRoots(t *testing.T) {
+	ctx := context.Background()
+	fs, err := filewalktestutil.NewMockFS("r", filewalktestutil.WithYAMLConfig(withDevicesSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sorted := range []bool{false, true} {
+		lf := &locateFlags{Sorted: sorted, SameDevice: true, Depth: -1}
+		lf.ScanSize = 100
+		collect := &collector{}
+		if err := (locateCmd{}).locateFS(ctx, fs, lf, collect.visit, []string{"r/a", "r/b", "--", "type=f"}); err != nil {
+			t.Fatal(err)
+		}
+		paths := []string{}
+		for _, found := range collect.found {
+			paths = append(paths, path.Join(found.prefix, found.name))
+		}
+		sort.Strings(paths)
+		if got, want := paths, []string{"r/a/fa", "r/b/fb"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("sorted %v: got %v, want %v", sorted, got, want)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"cloudeng.io/file"
+)
+
+type diffCmd struct{}
+
+type diffFlags struct {
+	WalkerFlags
+	Changes     string `subcmd:"changes,,'comma separated list of the changes to report, any of added, removed, renamed, resized, modified, perms or owner, the default is all of them'"`
+	Format      string `subcmd:"format,text,'output format, one of text, json (an array of objects) or ndjson (one object per line)'"`
+	QuietErrors string `subcmd:"quiet-errors,,'comma separated list of the classes of error that are not displayed as they are encountered when comparing a snapshot with the current tree, as per locate --quiet-errors'"`
+}
+
+// diffChanges are the changes reported for the entries that differ
+// between two snapshots.
+var diffChanges = []string{"added", "removed", "renamed", "resized", "modified", "perms", "owner"}
+
+// parseChanges parses the value of --changes, a comma separated list
+// of changes, an empty list selects all of them.
+func parseChanges(spec string) (map[string]bool, error) {
+	changes := map[string]bool{}
+	if len(spec) == 0 {
+		for _, c := range diffChanges {
+			changes[c] = true
+		}
+		return changes, nil
+	}
+	for _, c := range strings.Split(spec, ",") {
+		c = strings.TrimSpace(c)
+		if !slices.Contains(diffChanges, c) {
+			return nil, fmt.Errorf("unsupported --changes value: %q, use a comma separated list of %v", c, strings.Join(diffChanges, ", "))
+		}
+		changes[c] = true
+	}
+	return changes, nil
+}
+
+// splitDiffArgs returns the snapshots and expression specified by args,
+// the expression, if any, must follow a --. An error is returned if there
+// is no -- and any of args appears to be part of an expression rather
+// than a snapshot.
+func splitDiffArgs(args []string) (snapshots, expr []string, err error) {
+	for i, a := range args {
+		if a == "--" {
+			return args[:i], args[i+1:], nil
+		}
+	}
+	for _, a := range args {
+		if isExpressionArg(a) {
+			return nil, nil, fmt.Errorf("%q is not a snapshot, an expression must follow a --, ie. [<old-snapshot> [<new-snapshot>]] -- <expression>...", a)
+		}
+	}
+	return args, nil, nil
+}
+
+// isExpressionArg returns true if arg is not an existing file and is an
+// operator or contains an operand, ie. name=value, or a parenthesis.
+func isExpressionArg(arg string) bool {
+	if _, err := os.Stat(arg); err == nil {
+		return false
+	}
+	switch arg {
+	case "&&", "||", "!":
+		return true
+	}
+	return strings.ContainsAny(arg, "=()")
+}
+
+// diffRecord represents a single difference when displayed as json.
+type diffRecord struct {
+	Path    string      `json:"path"`
+	OldPath string      `json:"old_path,omitempty"`
+	Type    string      `json:"type"`
+	Changes []string    `json:"changes"`
+	Old     *statRecord `json:"old,omitempty"`
+	New     *statRecord `json:"new,omitempty"`
+}
+
+// difference represents an entry that differs between two snapshots,
+// old is nil for added entries and new for removed ones.
+type difference struct {
+	changes  []string
+	old, new *indexEntry
+}
+
+func (d difference) path() string {
+	if d.new != nil {
+		return d.new.path
+	}
+	return d.old.path
+}
+
+func (dc diffCmd) diff(ctx context.Context, values interface{}, args []string) error {
+	df := values.(*diffFlags)
+	if err := validateFormat(df.Format); err != nil {
+		return err
+	}
+	changes, err := parseChanges(df.Changes)
+	if err != nil {
+		return err
+	}
+	quiet, err := parseErrorClasses(df.QuietErrors)
+	if err != nil {
+		return err
+	}
+	snapshots, exprArgs, err := splitDiffArgs(args)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > 2 {
+		return fmt.Errorf("at most two snapshots may be compared")
+	}
+	expr, err := createExpr(exprArgs)
+	if err != nil {
+		return err
+	}
+	if expr.NeedsContent() {
+		return fmt.Errorf("contains and contains-fixed cannot be used with diff since snapshots do not record the contents of files")
+	}
+	if expr.NeedsUnindexed() {
+		return fmt.Errorf("%v cannot be used with diff since snapshots do not record access or change times or the number of hard links", unindexedOperands)
+	}
+	if len(snapshots) == 0 {
+		name, err := indexFile("")
+		if err != nil {
+			return err
+		}
+		snapshots = []string{name}
+	}
+	older, err := readIndexFile(snapshots[0])
+	if err != nil {
+		return err
+	}
+	out := newOutput(os.Stdout, os.Stderr, df.Format, false)
+	oc := newOutcome(quiet)
+	var newer *index
+	if len(snapshots) == 2 {
+		if newer, err = readIndexFile(snapshots[1]); err != nil {
+			return err
+		}
+	} else {
+		// Compare the snapshot with the current tree, as walked using
+		// the options that the snapshot was built with. The walk has its
+		// own outcome since only the differences reported, rather than
+		// the entries it records, are matches.
+		walked := newOutcome(quiet)
+		_, hdr, entries, err := buildIndex(ctx, older.indexHeader, nil, df.WalkerFlags, out, walked)
+		if err != nil {
+			return err
+		}
+		newer = newIndex(hdr, entries)
+		oc.mergeErrors(walked)
+	}
+	out.begin()
+	dc.report(ctx, out, oc, diffIndexes(older, newer), older, newer, changes, expr)
+	out.end()
+	if df.Format == textFormat {
+		oc.summarize(out)
+	}
+	return oc.err()
+}
+
+// report displays the differences that include any of the specified
+// changes and that match expr. Expressions are evaluated against the
+// newer entry, or the older one for entries that have been removed.
+func (dc diffCmd) report(ctx context.Context, out *output, oc *outcome, diffs []difference, older, newer *index, changes map[string]bool, expr expression) {
+	olderFS, newerFS := newIndexFS(older), newIndexFS(newer)
+	for _, d := range diffs {
+		if !slices.ContainsFunc(d.changes, func(c string) bool { return changes[c] }) {
+			continue
+		}
+		e, ifs := d.new, newerFS
+		if e == nil {
+			e, ifs = d.old, olderFS
+		}
+		if !expr.Eval(withStat{
+			ctx:        ctx,
+			name:       e.info.Name(),
+			path:       e.path,
+			fs:         ifs,
+			info:       e.info,
+			numEntries: int64(len(ifs.idx.children[e.path])),
+			depth:      ifs.idx.depth(e.path),
+		}) {
+			continue
+		}
+		oc.matched()
+		if out.format == textFormat {
+			p := d.path()
+			if d.old != nil && d.new != nil && d.old.path != d.new.path {
+				p = d.old.path + " -> " + d.new.path
+			}
+			out.text(fmt.Sprintf("%s: %s", strings.Join(d.changes, ","), p))
+			continue
+		}
+		r := diffRecord{
+			Path:    d.path(),
+			Type:    typeLetter(e.info.Mode()),
+			Changes: d.changes,
+			Old:     diffStatRecord(d.old),
+			New:     diffStatRecord(d.new),
+		}
+		if d.old != nil && d.old.path != r.Path {
+			r.OldPath = d.old.path
+		}
+		out.object(r.Path, r)
+	}
+}
+
+func diffStatRecord(e *indexEntry) *statRecord {
+	if e == nil {
+		return nil
+	}
+	xattr, _ := e.info.Sys().(file.XAttr)
+	user, group := userAndGroup(xattr)
+	return newStatRecord(&e.info, xattr, user, group)
+}
+
+// depth returns the depth of path relative to the starting location
+// that contains it.
+func (idx *index) depth(p string) int {
+	sep := idx.separator()
+	for _, r := range idx.roots {
+		if p == r {
+			return 0
+		}
+		if prefix := withSeparator(r, sep); strings.HasPrefix(p, prefix) {
+			return strings.Count(strings.TrimSuffix(p[len(prefix):], sep), sep) + 1
+		}
+	}
+	return 0
+}
+
+// dir returns the directory containing path.
+func (idx *index) dir(p string) string {
+	if idx.scheme == "file" {
+		return filepath.Dir(p)
+	}
+	return path.Dir(p)
+}
+
+// entryChanges returns the changes between two entries of the same type.
+func entryChanges(o, n indexEntry) []string {
+	var changes []string
+	if !n.info.IsDir() && o.info.Size() != n.info.Size() {
+		changes = append(changes, "resized")
+	}
+	if !o.info.ModTime().Equal(n.info.ModTime()) {
+		changes = append(changes, "modified")
+	}
+	if o.info.Mode() != n.info.Mode() {
+		changes = append(changes, "perms")
+	}
+	ox, _ := o.info.Sys().(file.XAttr)
+	nx, _ := n.info.Sys().(file.XAttr)
+	if ox.UID != nx.UID || ox.GID != nx.GID || ox.User != nx.User || ox.Group != nx.Group {
+		changes = append(changes, "owner")
+	}
+	return changes
+}
+
+// diffIndexes returns the differences between two indexes, sorted by
+// path. An entry whose type has changed is reported as having been
+// removed and then added. Entries that have been removed from one path
+// and added at another, but that have the same device and inode and
+// that have not been modified since the older index was built, are
+// reported as renamed, except for those whose rename is implied by that
+// of the directory containing them, which are reported only if they
+// have otherwise changed.
+func diffIndexes(older, newer *index) []difference {
+	var diffs []difference
+	var removed, added []int
+	for i, o := range older.entries {
+		j, ok := newer.paths[o.path]
+		if !ok || o.info.Mode().Type() != newer.entries[j].info.Mode().Type() {
+			removed = append(removed, i)
+			if ok {
+				added = append(added, j)
+			}
+			continue
+		}
+		if changes := entryChanges(o, newer.entries[j]); len(changes) > 0 {
+			diffs = append(diffs, difference{changes: changes, old: &older.entries[i], new: &newer.entries[j]})
+		}
+	}
+	for j, n := range newer.entries {
+		if _, ok := older.paths[n.path]; !ok {
+			added = append(added, j)
+		}
+	}
+	// Process the added entries in index order so that directories are
+	// processed before their contents.
+	sort.Ints(added)
+
+	type fileID struct {
+		device, inode uint64
+	}
+	byID := map[fileID][]int{}
+	for _, i := range removed {
+		if xattr, _ := older.entries[i].info.Sys().(file.XAttr); xattr.FileID != 0 {
+			id := fileID{xattr.Device, xattr.FileID}
+			byID[id] = append(byID[id], i)
+		}
+	}
+	renamed := map[int]bool{}
+	renamedDirs := map[string]string{}
+	for _, j := range added {
+		n := &newer.entries[j]
+		xattr, _ := n.info.Sys().(file.XAttr)
+		var o *indexEntry
+		// Inodes are reused, hence an entry that was created or modified
+		// since the older index was built is never considered to be a
+		// renamed one.
+		if xattr.FileID != 0 && n.info.ModTime().Before(older.built) {
+			id := fileID{xattr.Device, xattr.FileID}
+			for k, i := range byID[id] {
+				if older.entries[i].info.Mode().Type() == n.info.Mode().Type() {
+					o = &older.entries[i]
+					renamed[i] = true
+					byID[id] = slices.Delete(byID[id], k, k+1)
+					break
+				}
+			}
+		}
+		if o == nil {
+			diffs = append(diffs, difference{changes: []string{"added"}, new: n})
+			continue
+		}
+		if n.info.IsDir() {
+			renamedDirs[o.path] = n.path
+		}
+		changes := entryChanges(*o, *n)
+		implied := o.info.Name() == n.info.Name() && renamedDirs[older.dir(o.path)] == newer.dir(n.path)
+		if !implied {
+			changes = append([]string{"renamed"}, changes...)
+		}
+		if len(changes) > 0 {
+			diffs = append(diffs, difference{changes: changes, old: o, new: n})
+		}
+	}
+	for _, i := range removed {
+		if !renamed[i] {
+			diffs = append(diffs, difference{changes: []string{"removed"}, old: &older.entries[i]})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if pi, pj := diffs[i].path(), diffs[j].path(); pi != pj {
+			return pi < pj
+		}
+		// Removals precede additions for entries whose type changed.
+		return diffs[i].new == nil
+	})
+	return diffs
+}
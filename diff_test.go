@@ -0,0 +1,179 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	j := func(p ...string) string { return filepath.Join(append([]string{root}, p...)...) }
+	if err := os.MkdirAll(j("dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"keep", "grow", "perm", "gone", "mv-src", "retyped", filepath.Join("dir", "a"), filepath.Join("dir", "b")} {
+		if err := os.WriteFile(j(f), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readIndex := func(name string) *index {
+		buildIndexFile(ctx, t, name, root, nil)
+		idx, err := readIndexFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return idx
+	}
+	older := readIndex(filepath.Join(tmp, "older"))
+
+	if err := os.WriteFile(j("grow"), []byte("xyz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(j("perm"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(j("gone")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(j("mv-src"), j("mv-dst")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(j("dir"), j("dir2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(j("retyped")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(j("retyped"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(j("new"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	newer := readIndex(filepath.Join(tmp, "newer"))
+
+	diff := func(spec string, expr ...string) string {
+		changes, err := parseChanges(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := createExpr(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		out := newOutput(&buf, &buf, textFormat, false)
+		diffCmd{}.report(ctx, out, newOutcome(nil), diffIndexes(older, newer), older, newer, changes, e)
+		return strings.ReplaceAll(buf.String(), root, "R")
+	}
+
+	got := diff("")
+	for _, want := range []string{
+		"modified: R\n",
+		"removed: R/gone\n",
+		"resized,modified: R/grow\n",
+		"renamed: R/mv-src -> R/mv-dst\n",
+		"added: R/new\n",
+		"perms: R/perm\n",
+		"removed: R/retyped\nadded: R/retyped\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not found in %q", want, got)
+		}
+	}
+	// The contents of a renamed directory are not reported as renamed.
+	if !strings.Contains(got, "R/dir -> R/dir2\n") || strings.Contains(got, "R/dir2/") {
+		t.Errorf("unexpected output for a renamed directory: %q", got)
+	}
+	if strings.Contains(got, "keep") {
+		t.Errorf("unchanged file reported: %q", got)
+	}
+
+	if got, want := diff("added,removed", "type=f"), "removed: R/gone\nadded: R/new\nremoved: R/retyped\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := diff("", "name=g*"), "removed: R/gone\nresized,modified: R/grow\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := parseChanges("added,bogus"); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	var buf bytes.Buffer
+	out := newOutput(&buf, &buf, ndjsonFormat, false)
+	changes, _ := parseChanges("renamed")
+	diffCmd{}.report(ctx, out, newOutcome(nil), diffIndexes(older, newer), older, newer, changes, expression{})
+	var records []diffRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r diffRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if got, want := len(records), 2; got != want {
+		t.Fatalf("got %v, want %v: %v", got, want, records)
+	}
+	r := records[1]
+	if r.Path != j("mv-dst") || r.OldPath != j("mv-src") || r.Type != "f" || r.Old == nil || r.New == nil || r.Old.Inode != r.New.Inode {
+		t.Errorf("unexpected record: %+v", r)
+	}
+}
+
+func TestDiffArgs(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "a=b")
+	if err := os.WriteFile(snapshot, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		args            []string
+		snapshots, expr string
+	}{
+		{nil, "", ""},
+		{[]string{"old", "new"}, "old,new", ""},
+		{[]string{"old", "--", "name=x"}, "old", "name=x"},
+		{[]string{"--", "(", "name=x", ")"}, "", "(,name=x,)"},
+		{[]string{snapshot}, snapshot, ""},
+	} {
+		snapshots, expr, err := splitDiffArgs(tc.args)
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if got, want := strings.Join(snapshots, ","), tc.snapshots; got != want {
+			t.Errorf("%v: got %v, want %v", tc.args, got, want)
+		}
+		if got, want := strings.Join(expr, ","), tc.expr; got != want {
+			t.Errorf("%v: got %v, want %v", tc.args, got, want)
+		}
+	}
+	for _, args := range [][]string{
+		{"name=x"},
+		{"old", "type=f"},
+		{"old", "new", "(", "name=x"},
+		{"old", "!", "type=f"},
+	} {
+		if _, _, err := splitDiffArgs(args); err == nil || !strings.Contains(err.Error(), "must follow a --") {
+			t.Errorf("%v: unexpected or missing error: %v", args, err)
+		}
+	}
+	for _, expr := range []string{"atime>2d", "ctime<1h", "nlink>1"} {
+		df := &diffFlags{Format: textFormat}
+		err := diffCmd{}.diff(context.Background(), df, []string{"old", "--", expr})
+		if err == nil || !strings.Contains(err.Error(), "cannot be used with diff") {
+			t.Errorf("%v: unexpected or missing error: %v", expr, err)
+		}
+	}
+}
@@ -5,63 +5,325 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
 
 	"cloudeng.io/file"
 )
 
+// exclusion represents a single pattern used to exclude directories,
+// it is one of:
+//
+//	re:   a regular expression matched against the full path name
+//	glob: a glob matched against the path relative to the starting location
+//	name: a glob matched against the name of the directory
+type exclusion struct {
+	kind     string
+	pattern  string
+	re       *regexp.Regexp
+	excluded atomic.Int64
+}
+
+func newExclusion(kind, pattern string) (*exclusion, error) {
+	ex := &exclusion{kind: kind, pattern: pattern}
+	var err error
+	switch kind {
+	case "re":
+		ex.re, err = regexp.Compile(pattern)
+	case "glob":
+		ex.re, err = regexp.Compile("^" + globToRegexp(strings.TrimPrefix(pattern, "/")) + "$")
+	case "name":
+		_, err = path.Match(pattern, "")
+	default:
+		err = fmt.Errorf("unsupported exclusion type: %v", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclusion: %v: %v", pattern, err)
+	}
+	return ex, nil
+}
+
+func (ex *exclusion) match(pathname, rel string) bool {
+	switch ex.kind {
+	case "re":
+		return ex.re.MatchString(pathname)
+	case "glob":
+		return len(rel) > 0 && ex.re.MatchString(rel)
+	}
+	if len(rel) == 0 {
+		return false
+	}
+	matched, _ := path.Match(ex.pattern, path.Base(rel))
+	return matched
+}
+
+func (ex *exclusion) String() string {
+	return ex.kind + ":" + ex.pattern
+}
+
+// exclusions determines which directories are not to be searched,
+// either because they match an exclusion or because they are not
+// within a subtree that matches any of the inclusions, if any are
+// specified. The number of directories skipped is recorded for each
+// exclusion, and for the inclusions as a whole.
 type exclusions struct {
-	regexps []*regexp.Regexp
+	patterns    []*exclusion
+	includes    [][]string
+	notIncluded atomic.Int64
 }
 
-func newExclusions(patterns []string) (exclusions, error) {
-	var ex exclusions
-	for _, p := range patterns {
-		re, err := regexp.Compile(p)
-		if err != nil {
-			return exclusions{}, err
+func newExclusions(regexps, globs, names, includes []string) (*exclusions, error) {
+	ex := &exclusions{}
+	for _, p := range []struct {
+		kind     string
+		patterns []string
+	}{
+		{"re", regexps},
+		{"glob", globs},
+		{"name", names},
+	} {
+		for _, pattern := range p.patterns {
+			if err := ex.add(p.kind, pattern); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, pattern := range includes {
+		components := strings.Split(strings.Trim(pattern, "/"), "/")
+		for _, c := range components {
+			if _, err := path.Match(c, ""); err != nil {
+				return nil, fmt.Errorf("invalid inclusion: %v: %v", pattern, err)
+			}
 		}
-		ex.regexps = append(ex.regexps, re)
+		ex.includes = append(ex.includes, components)
 	}
 	return ex, nil
 }
 
-func (e exclusions) Match(path string) bool {
-	if len(e.regexps) == 0 {
+func (e *exclusions) add(kind, pattern string) error {
+	ex, err := newExclusion(kind, pattern)
+	if err != nil {
+		return err
+	}
+	e.patterns = append(e.patterns, ex)
+	return nil
+}
+
+// readFrom reads exclusions from rd, one per line, blank lines and
+// those starting with # are ignored. Each line may be prefixed with re:,
+// glob: or name: to specify the type of pattern, the default is glob.
+func (e *exclusions) readFrom(rd io.Reader) error {
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		kind, pattern := "glob", line
+		if k, p, ok := strings.Cut(line, ":"); ok && (k == "re" || k == "glob" || k == "name") {
+			kind, pattern = k, p
+		}
+		if err := e.add(kind, pattern); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// Match returns true if the directory at the specified depth, relative
+// to its starting location, is not to be searched.
+func (e *exclusions) Match(pathname string, depth int) bool {
+	if e == nil {
 		return false
 	}
-	for _, re := range e.regexps {
-		if re.MatchString(path) {
+	rel := relativeToRoot(pathname, depth)
+	for _, ex := range e.patterns {
+		if ex.match(pathname, rel) {
+			ex.excluded.Add(1)
+			return true
+		}
+	}
+	if depth > 0 && len(e.includes) > 0 {
+		if within, partial := e.included(rel); !within && !partial {
+			e.notIncluded.Add(1)
 			return true
 		}
 	}
 	return false
 }
 
+// Included returns true if the file or directory at the specified depth,
+// relative to its starting location, is within one of the subtrees that
+// match the inclusions, if any, and hence may be reported. The starting
+// location itself is always included.
+func (e *exclusions) Included(pathname string, depth int) bool {
+	if e == nil || len(e.includes) == 0 || depth == 0 {
+		return true
+	}
+	within, _ := e.included(relativeToRoot(pathname, depth))
+	return within
+}
+
+// included returns whether rel is within a subtree that matches one of
+// the inclusions and whether it may contain such a subtree.
+func (e *exclusions) included(rel string) (within, partial bool) {
+	components := strings.Split(rel, "/")
+	for _, include := range e.includes {
+		w, p := matchInclusion(include, components)
+		if w {
+			return true, true
+		}
+		partial = partial || p
+	}
+	return false, partial
+}
+
+// matchInclusion matches components against the components of an
+// inclusion, where ** matches any number of components, including none.
+// It returns whether a prefix of components matches the inclusion, ie.
+// whether it is within the included subtree, and whether components may
+// be extended to match it, ie. whether it may contain such a subtree.
+func matchInclusion(include, components []string) (within, partial bool) {
+	if len(include) == 0 {
+		return true, false
+	}
+	if len(components) == 0 {
+		for _, c := range include {
+			if c != "**" {
+				return false, true
+			}
+		}
+		return true, true
+	}
+	if include[0] == "**" {
+		within, partial = matchInclusion(include[1:], components)
+		if within {
+			return true, partial
+		}
+		w, p := matchInclusion(include, components[1:])
+		return w, partial || p
+	}
+	if matched, _ := path.Match(include[0], components[0]); !matched {
+		return false, false
+	}
+	return matchInclusion(include[1:], components[1:])
+}
+
+// relativeToRoot returns the last depth components of pathname, ie. its
+// path relative to the starting location, using / as the separator.
+func relativeToRoot(pathname string, depth int) string {
+	if depth <= 0 {
+		return ""
+	}
+	pathname = strings.TrimRight(pathname, `/\`)
+	end := len(pathname)
+	for i := len(pathname) - 1; i >= 0; i-- {
+		if c := pathname[i]; c == '/' || c == filepath.Separator {
+			if depth--; depth == 0 {
+				return filepath.ToSlash(pathname[i+1 : end])
+			}
+		}
+	}
+	return filepath.ToSlash(pathname)
+}
+
+// exclusionCount is the number of directories skipped by a single
+// exclusion, or by the inclusions.
+type exclusionCount struct {
+	Pattern     string `json:"pattern"`
+	Directories int64  `json:"directories"`
+}
+
+// counts returns the number of directories skipped by each exclusion
+// and by the inclusions.
+func (e *exclusions) counts() []exclusionCount {
+	if e == nil {
+		return nil
+	}
+	var counts []exclusionCount
+	for _, ex := range e.patterns {
+		counts = append(counts, exclusionCount{Pattern: ex.String(), Directories: ex.excluded.Load()})
+	}
+	if len(e.includes) > 0 {
+		counts = append(counts, exclusionCount{Pattern: "not included", Directories: e.notIncluded.Load()})
+	}
+	return counts
+}
+
+// report writes the number of directories skipped by each exclusion,
+// and by the inclusions, to w.
+func (e *exclusions) report(w io.Writer) {
+	for _, c := range e.counts() {
+		fmt.Fprintf(w, "excluded: %v: %v directories\n", c.Pattern, c.Directories)
+	}
+}
+
+// sameDevice limits a search to the devices of the starting locations,
+// a directory is searched if it is on the same device as the starting
+// location that it is below.
 type sameDevice struct {
-	device uint64
+	roots   []string
+	devices []uint64
 }
 
-func newSameDevice(ctx context.Context, fs file.FS, pathname string) (sameDevice, error) {
-	info, err := fs.Stat(ctx, pathname)
-	if err != nil {
-		return sameDevice{}, err
+func newSameDevice(ctx context.Context, fs file.FS, pathnames ...string) (sameDevice, error) {
+	var sd sameDevice
+	for _, pathname := range pathnames {
+		info, err := fs.Stat(ctx, pathname)
+		if err != nil {
+			return sameDevice{}, err
+		}
+		xattr, err := fs.XAttr(ctx, pathname, info)
+		if err != nil {
+			return sameDevice{}, err
+		}
+		if xattr.Device == 0 {
+			continue
+		}
+		// The paths of the directories below a local starting location
+		// are cleaned.
+		if fs.Scheme() == "file" {
+			pathname = filepath.Clean(pathname)
+		}
+		sd.roots = append(sd.roots, pathname)
+		sd.devices = append(sd.devices, xattr.Device)
 	}
-	xattr, err := fs.XAttr(ctx, pathname, info)
-	if err != nil {
-		return sameDevice{}, err
+	return sd, nil
+}
+
+// device returns the device of the starting location that dirName is
+// below, or false if there is none.
+func (sd sameDevice) device(dirName string) (uint64, bool) {
+	n := -1
+	for i, r := range sd.roots {
+		if hasPathPrefix(dirName, r) && (n < 0 || len(r) > len(sd.roots[n])) {
+			n = i
+		}
 	}
-	return sameDevice{device: xattr.Device}, nil
+	if n < 0 {
+		return 0, false
+	}
+	return sd.devices[n], true
 }
 
 func (sd sameDevice) Match(ctx context.Context, fs file.FS, dirName string, dirInfo file.Info) (bool, error) {
-	if sd.device == 0 {
+	if len(sd.devices) == 0 {
 		return true, nil
 	}
 	xattr, err := fs.XAttr(ctx, dirName, dirInfo)
 	if err != nil {
 		return false, err
 	}
-	return sd.device == xattr.Device, nil
+	if device, ok := sd.device(dirName); ok {
+		return xattr.Device == device, nil
+	}
+	return slices.Contains(sd.devices, xattr.Device), nil
 }
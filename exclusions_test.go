@@ -0,0 +1,210 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloudeng.io/cmdutil/flags"
+)
+
+func TestRelativeToRoot(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		depth int
+		rel   string
+	}{
+		{"r", 0, ""},
+		{"r/a", 1, "a"},
+		{"r/a/b", 2, "a/b"},
+		{"/a/b/", 1, "b"},
+		{"/a", 1, "a"},
+		{"a", 1, "a"},
+		{"s3://bucket/a/b", 2, "a/b"},
+	} {
+		if got, want := relativeToRoot(tc.path, tc.depth), tc.rel; got != want {
+			t.Errorf("%v: %v: got %v, want %v", tc.path, tc.depth, got, want)
+		}
+	}
+}
+
+func TestExclusionMatch(t *testing.T) {
+	ex, err := newExclusions([]string{"/tmp$"}, []string{"**/build", "src/gen"}, []string{"vendor", "*.cache"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ex.readFrom(strings.NewReader("# comment\n\nname:testdata\nre:/skip/\nout/*\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path     string
+		depth    int
+		excluded bool
+	}{
+		{"r", 0, false},
+		{"r/tmp", 1, true},
+		{"r/build", 1, true},
+		{"r/a/b/build", 3, true},
+		{"r/src/gen", 2, true},
+		{"r/a/src/gen", 3, false},
+		{"r/a/vendor", 2, true},
+		{"r/vendored", 1, false},
+		{"r/go.cache", 1, true},
+		{"r/a/testdata", 2, true},
+		{"r/skip/a", 2, true},
+		{"r/out/a", 2, true},
+		{"r/out", 1, false},
+		{"vendor", 0, false},
+	} {
+		if got, want := ex.Match(tc.path, tc.depth), tc.excluded; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+	}
+	var out bytes.Buffer
+	ex.report(&out)
+	if got, want := out.String(), `excluded: re:/tmp$: 1 directories
+excluded: glob:**/build: 2 directories
+excluded: glob:src/gen: 1 directories
+excluded: name:vendor: 1 directories
+excluded: name:*.cache: 1 directories
+excluded: name:testdata: 1 directories
+excluded: re:/skip/: 1 directories
+excluded: glob:out/*: 1 directories
+`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, err := range []error{
+		func() error { _, err := newExclusions([]string{"("}, nil, nil, nil); return err }(),
+		func() error { _, err := newExclusions(nil, nil, []string{"["}, nil); return err }(),
+		func() error { _, err := newExclusions(nil, nil, nil, []string{"a/["}); return err }(),
+		ex.readFrom(strings.NewReader("name:[")),
+	} {
+		if err == nil {
+			t.Errorf("expected an error")
+		}
+	}
+}
+
+func TestInclusions(t *testing.T) {
+	ex, err := newExclusions(nil, nil, nil, []string{"src/**", "docs/*/img"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path     string
+		depth    int
+		excluded bool
+	}{
+		{"r", 0, false},
+		{"r/src", 1, false},
+		{"r/src/a/b", 3, false},
+		{"r/docs", 1, false},
+		{"r/docs/x", 2, false},
+		{"r/docs/x/img", 3, false},
+		{"r/docs/x/txt", 3, true},
+		{"r/docs/x/img/a", 4, false},
+		{"r/other", 1, true},
+	} {
+		if got, want := ex.Match(tc.path, tc.depth), tc.excluded; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+	}
+
+	// **/testdata may match below any directory, but only the contents
+	// of testdata directories are included.
+	ex, err = newExclusions(nil, nil, nil, []string{"**/testdata", "a/**/b/*.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Match("r/other/deeper", 2) {
+		t.Errorf("r/other/deeper: should not be excluded")
+	}
+	for _, tc := range []struct {
+		path     string
+		depth    int
+		included bool
+	}{
+		{"r", 0, true},
+		{"r/x.go", 1, false},
+		{"r/testdata", 1, true},
+		{"r/other/testdata", 2, true},
+		{"r/other/testdata/x/y.go", 4, true},
+		{"r/other/deeper", 2, false},
+		{"r/a/b/x.go", 3, true},
+		{"r/a/c/d/b/x.go", 5, true},
+		{"r/a/c/d/x.go", 4, false},
+	} {
+		if got, want := ex.Included(tc.path, tc.depth), tc.included; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+	}
+	var out bytes.Buffer
+	ex.report(&out)
+	if got, want := out.String(), "excluded: not included: 0 directories\n"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExclusionsWalk(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	excludeFrom := filepath.Join(tmpDir, "exclusions")
+	if err := os.WriteFile(excludeFrom, []byte("name:.git\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		lf     locateFlags
+		output string
+	}{
+		{locateFlags{ExcludeFrom: excludeFrom},
+			"r/g.ts,r/node_modules/a.ts,r/node_modules/m/b.ts,r/src/d.ts,r/src/node_modules/f.ts"},
+		{locateFlags{ExcludeFrom: excludeFrom, ExcludeGlobs: repeating("node_modules")},
+			"r/g.ts,r/src/d.ts,r/src/node_modules/f.ts"},
+		{locateFlags{ExcludeNames: repeating("node_modules", ".git")},
+			"r/g.ts,r/src/d.ts"},
+		{locateFlags{Includes: repeating("src")},
+			"r/src/d.ts,r/src/node_modules/f.ts"},
+		{locateFlags{Includes: repeating("src"), ExcludeNames: repeating("node_modules")},
+			"r/src/d.ts"},
+		{locateFlags{Includes: repeating("**/node_modules")},
+			"r/node_modules/a.ts,r/node_modules/m/b.ts,r/src/node_modules/f.ts"},
+	} {
+		for _, sorted := range []bool{false, true} {
+			lf := tc.lf
+			lf.Sorted, lf.Depth, lf.Format = sorted, -1, textFormat
+			lf.ScanSize = 2
+			out, errs := locateOutput(ctx, t, &lf, pruneSpec, "r", "name=*.ts")
+			if len(errs) > 0 {
+				t.Errorf("unexpected errors: %v", errs)
+			}
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			sort.Strings(lines)
+			if got, want := strings.Join(lines, ","), tc.output; got != want {
+				t.Errorf("%+v: sorted %v: got %v, want %v", tc.lf, sorted, got, want)
+			}
+		}
+	}
+}
+
+// flagExclusions returns the exclusions specified by lf as a walker
+// option, as created by locate.
+func flagExclusions(t *testing.T, lf *locateFlags) walkerOption {
+	ex, err := lf.exclusions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return withExclusions(ex)
+}
+
+func repeating(values ...string) flags.Repeating {
+	return flags.Repeating{Values: values}
+}
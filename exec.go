@@ -0,0 +1,219 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// maxExecBatch is the maximum number of paths passed to a single
+	// command when batching.
+	maxExecBatch = 1000
+	// maxExecBatchBytes is the maximum total size of the paths passed to
+	// a single command when batching.
+	maxExecBatchBytes = 128 * 1024
+)
+
+// execRunner runs a command for each match, or for batches of matches,
+// using a bounded pool of workers that run concurrently with the walker.
+// The output of each command is buffered and written in its entirety
+// once the command completes to avoid interleaving the output of
+// concurrent commands. Commands that fail are reported via report.
+type execRunner struct {
+	ctx    context.Context
+	args   []string
+	batch  bool
+	out    *output
+	report func(path string, err error)
+	ch     chan []string
+	wg     sync.WaitGroup
+
+	mu           sync.Mutex
+	pending      []string
+	pendingBytes int
+
+	commands, failures int64
+}
+
+// parseExecCommand parses the command to be run for each match. The
+// command is split into arguments as per splitCommand and {} is replaced
+// by the path of the match. If the command ends in {} + then the matches
+// are passed in batches, with {} being replaced by multiple paths.
+func parseExecCommand(command string) (args []string, batch bool, err error) {
+	args, err = splitCommand(command)
+	if err != nil {
+		return nil, false, fmt.Errorf("--exec: %v: %v", err, command)
+	}
+	if len(args) == 0 {
+		return nil, false, fmt.Errorf("no command specified for --exec")
+	}
+	if n := len(args); n >= 2 && args[n-1] == "+" {
+		if args[n-2] != "{}" {
+			return nil, false, fmt.Errorf("--exec: {} must immediately precede +: %v", command)
+		}
+		return args[:n-1], true, nil
+	}
+	return args, false, nil
+}
+
+// splitCommand splits a command into arguments separated by white space
+// using a subset of the quoting rules of the shell: text within single
+// quotes is used as is, text within double quotes is used as is except
+// that a backslash escapes a following ", \, $ or `, and a backslash
+// outside of quotes escapes the following character.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			continue
+		case c == '\\':
+			if i+1 < len(command) {
+				i++
+				arg.WriteByte(command[i])
+			}
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			arg.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("\"\\$`", command[i+1]) >= 0 {
+					i++
+				}
+				arg.WriteByte(command[i])
+			}
+			if i == len(command) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+		default:
+			arg.WriteByte(c)
+		}
+		inArg = true
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+func newExecRunner(ctx context.Context, command string, concurrency int, out *output, report func(path string, err error)) (*execRunner, error) {
+	args, batch, err := parseExecCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(-1)
+	}
+	er := &execRunner{
+		ctx:    ctx,
+		args:   args,
+		batch:  batch,
+		out:    out,
+		report: report,
+		ch:     make(chan []string, concurrency*2),
+	}
+	er.wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer er.wg.Done()
+			for paths := range er.ch {
+				er.run(paths)
+			}
+		}()
+	}
+	return er, nil
+}
+
+// add is called for every match.
+func (er *execRunner) add(path string) {
+	if !er.batch {
+		er.ch <- []string{path}
+		return
+	}
+	er.mu.Lock()
+	er.pending = append(er.pending, path)
+	er.pendingBytes += len(path)
+	var paths []string
+	if len(er.pending) >= maxExecBatch || er.pendingBytes >= maxExecBatchBytes {
+		paths = er.pending
+		er.pending, er.pendingBytes = nil, 0
+	}
+	er.mu.Unlock()
+	if len(paths) > 0 {
+		er.ch <- paths
+	}
+}
+
+// commandFor returns the command line to be run for the supplied paths.
+func (er *execRunner) commandFor(paths []string) []string {
+	if er.batch {
+		args := make([]string, 0, len(er.args)+len(paths))
+		args = append(args, er.args[:len(er.args)-1]...)
+		return append(args, paths...)
+	}
+	args := make([]string, len(er.args))
+	for i, a := range er.args {
+		args[i] = strings.ReplaceAll(a, "{}", paths[0])
+	}
+	return args
+}
+
+func (er *execRunner) run(paths []string) {
+	args := er.commandFor(paths)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(er.ctx, args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	atomic.AddInt64(&er.commands, 1)
+	er.out.commandOutput(stdout.Bytes(), stderr.Bytes())
+	if err == nil {
+		return
+	}
+	atomic.AddInt64(&er.failures, 1)
+	if len(paths) > 1 {
+		err = fmt.Errorf("%v: %v (and %v other paths)", args[0], err, len(paths)-1)
+	} else {
+		err = fmt.Errorf("%v: %v", args[0], err)
+	}
+	er.report(paths[0], err)
+}
+
+// wait runs any remaining batch, waits for all commands to complete and
+// returns an error if any of them failed.
+func (er *execRunner) wait() error {
+	er.mu.Lock()
+	if len(er.pending) > 0 {
+		er.ch <- er.pending
+		er.pending = nil
+	}
+	er.mu.Unlock()
+	close(er.ch)
+	er.wg.Wait()
+	if er.failures > 0 {
+		return fmt.Errorf("%v out of %v commands failed", er.failures, er.commands)
+	}
+	return nil
+}
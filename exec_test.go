@@ -0,0 +1,104 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseExecCommand(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		args    []string
+		batch   bool
+	}{
+		{"echo {}", []string{"echo", "{}"}, false},
+		{"echo x{}y z", []string{"echo", "x{}y", "z"}, false},
+		{"echo -n {} +", []string{"echo", "-n", "{}"}, true},
+		{`echo "a b" {}`, []string{"echo", "a b", "{}"}, false},
+		{`sh -c 'echo "$1"' x {}`, []string{"sh", "-c", `echo "$1"`, "x", "{}"}, false},
+		{`echo a\ b "c \"d\"" '' {}`, []string{"echo", "a b", `c "d"`, "", "{}"}, false},
+	} {
+		args, batch, err := parseExecCommand(tc.command)
+		if err != nil {
+			t.Errorf("%v: %v", tc.command, err)
+			continue
+		}
+		if got, want := args, tc.args; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", tc.command, got, want)
+		}
+		if got, want := batch, tc.batch; got != want {
+			t.Errorf("%v: got %v, want %v", tc.command, got, want)
+		}
+	}
+	for _, command := range []string{"", "  ", "echo {} x +", `echo "a {}`, "echo 'a {}"} {
+		if _, _, err := parseExecCommand(command); err == nil {
+			t.Errorf("%q: expected an error", command)
+		}
+	}
+}
+
+func TestExec(t *testing.T) {
+	ctx := context.Background()
+	for _, sorted := range []bool{false, true} {
+		lf := &locateFlags{Sorted: sorted, Depth: -1, Format: textFormat, Exec: "echo match: {}"}
+		lf.ScanSize = 100
+		out, errs := locateOutput(ctx, t, lf, withSizesSpec, "r", "type=f")
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors: %v", errs)
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		sort.Strings(lines)
+		if got, want := strings.Join(lines, ","), "match: r/d0/f1,match: r/f0"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		lf.Exec = "echo {} +"
+		out, _ = locateOutput(ctx, t, lf, withSizesSpec, "r", "type=f")
+		fields := strings.Fields(out)
+		sort.Strings(fields)
+		if got, want := strings.Join(fields, ","), "r/d0/f1,r/f0"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := strings.Count(out, "\n"), 1; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestExecFailures(t *testing.T) {
+	ctx := context.Background()
+	var out, errs bytes.Buffer
+	o := newOutput(&out, &errs, textFormat, false)
+	v := visit{ctx: ctx, out: o, outcome: newOutcome(nil)}
+	er, err := newExecRunner(ctx, "false {}", 2, o, v.error)
+	if err != nil {
+		t.Fatal(err)
+	}
+	er.add("a")
+	er.add("b")
+	err = er.wait()
+	if err == nil || err.Error() != "2 out of 2 commands failed" {
+		t.Errorf("unexpected or missing error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(errs.String()), "\n")
+	sort.Strings(lines)
+	if got, want := strings.Join(lines, ","), "a: false: exit status 1,b: false: exit status 1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Failures are recorded, and hence summarized, as errors.
+	if err := v.outcome.err(); !errors.Is(err, errSearchErrors) {
+		t.Errorf("got %v, want %v", err, errSearchErrors)
+	}
+	if g := v.outcome.errors["other"]; g == nil || g.count != 2 {
+		t.Errorf("got %+v, want 2 other errors", g)
+	}
+}
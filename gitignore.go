@@ -0,0 +1,248 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/filewalk"
+)
+
+// gitExclude is the file, relative to the root of a repository, that
+// contains the repository's own gitignore style rules. It is only read
+// in directories that contain a .git directory and hence not for
+// worktrees and submodules, whose .git is a file that refers to a
+// directory elsewhere.
+const gitExclude = ".git/info/exclude"
+
+// ignoreFiles are the files, relative to each directory, that contain
+// gitignore style rules, in increasing order of precedence, all of which
+// take precedence over gitExclude.
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// ignoreRule represents a single gitignore pattern.
+type ignoreRule struct {
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// parseIgnoreRule parses a single line of a gitignore file, it returns
+// false for blank lines and comments.
+func parseIgnoreRule(line string) (ignoreRule, bool, error) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpaces(line)
+	if len(line) == 0 || line[0] == '#' {
+		return ignoreRule{}, false, nil
+	}
+	var rule ignoreRule
+	switch {
+	case line[0] == '!':
+		rule.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if len(line) == 0 {
+		return ignoreRule{}, false, nil
+	}
+	// Patterns that contain a separator, other than at the end, are
+	// relative to the directory containing the gitignore file, all
+	// others may match at any level below it.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if !anchored {
+		line = "**/" + line
+	}
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		return ignoreRule{}, false, err
+	}
+	rule.re = re
+	return rule, true, nil
+}
+
+// trimTrailingSpaces removes trailing spaces unless they are escaped
+// with a backslash.
+func trimTrailingSpaces(line string) string {
+	for len(line) > 0 && line[len(line)-1] == ' ' {
+		if len(line) > 1 && line[len(line)-2] == '\\' {
+			return line[:len(line)-2] + " "
+		}
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// globToRegexp translates a gitignore glob to a regular expression,
+// ** matches any number of directories, * and ? do not match a /.
+func globToRegexp(glob string) string {
+	var out strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			out.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			out.WriteString("/.*")
+			i += 2
+		case c == '*':
+			out.WriteString("[^/]*")
+		case c == '?':
+			out.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			out.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				out.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			out.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			out.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return out.String()
+}
+
+// ignoreRules represents the gitignore rules that apply to a directory.
+// The rules for a directory are stacked on top of those for its parent
+// and are never modified once created, so they may be shared, without
+// locking, by all of the goroutines that scan its subdirectories.
+type ignoreRules struct {
+	parent *ignoreRules
+	dir    string
+	rules  []ignoreRule
+}
+
+// ignored returns true if path, which must be within the directory that
+// the rules apply to, should be ignored. Rules in deeper directories
+// take precedence over those in their parents and later rules over
+// earlier ones.
+func (ir *ignoreRules) ignored(path string, isDir bool) bool {
+	for r := ir; r != nil; r = r.parent {
+		rel := strings.TrimPrefix(path, r.dir)
+		rel = strings.TrimLeft(strings.ReplaceAll(rel, `\`, "/"), "/")
+		for i := len(r.rules) - 1; i >= 0; i-- {
+			rule := r.rules[i]
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				return !rule.negate
+			}
+		}
+	}
+	return false
+}
+
+// gitignore loads and applies gitignore rules.
+type gitignore struct {
+	fs file.FS
+}
+
+func newGitignore(fs file.FS) *gitignore {
+	return &gitignore{fs: fs}
+}
+
+// load returns the rules that apply to dir, given those of its parent.
+// The parent's rules are returned if dir contains no ignore files.
+func (g *gitignore) load(ctx context.Context, parent *ignoreRules, dir string) (*ignoreRules, error) {
+	var rules []ignoreRule
+	var errs []error
+	names := ignoreFiles
+	isRoot, err := g.isRepositoryRoot(ctx, dir)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if isRoot {
+		names = append([]string{gitExclude}, ignoreFiles...)
+	}
+	for _, name := range names {
+		r, err := g.read(ctx, g.fs.Join(dir, name))
+		if err != nil {
+			errs = append(errs, err)
+		}
+		rules = append(rules, r...)
+	}
+	if len(rules) == 0 {
+		return parent, errors.Join(errs...)
+	}
+	return &ignoreRules{parent: parent, dir: dir, rules: rules}, errors.Join(errs...)
+}
+
+// isRepositoryRoot returns true if dir contains a .git directory.
+func (g *gitignore) isRepositoryRoot(ctx context.Context, dir string) (bool, error) {
+	fi, err := g.fs.Lstat(ctx, g.fs.Join(dir, ".git"))
+	if err != nil {
+		if g.fs.IsNotExist(err) || errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return fi.IsDir(), nil
+}
+
+func (g *gitignore) read(ctx context.Context, path string) ([]ignoreRule, error) {
+	f, err := g.fs.OpenCtx(ctx, path)
+	if err != nil {
+		if g.fs.IsNotExist(err) || errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return parseIgnoreRules(f)
+}
+
+func parseIgnoreRules(rd io.Reader) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		rule, ok, err := parseIgnoreRule(sc.Text())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, sc.Err()
+}
+
+// filter returns the entries in contents that are not ignored, the .git
+// directory is always ignored.
+func (g *gitignore) filter(ir *ignoreRules, parent string, contents []filewalk.Entry) []filewalk.Entry {
+	filtered := make([]filewalk.Entry, 0, len(contents))
+	for _, c := range contents {
+		if c.IsDir() && c.Name == ".git" {
+			continue
+		}
+		if ir.ignored(g.fs.Join(parent, c.Name), c.IsDir()) {
+			continue
+		}
+		filtered = append(filtered, c)
+	}
+	return filtered
+}
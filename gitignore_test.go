@@ -0,0 +1,205 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/localfs"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"*.o", "a.o", false, true},
+		{"*.o", "x/y/a.o", false, true},
+		{"*.o", "a.oo", false, false},
+		{"/a.o", "a.o", false, true},
+		{"/a.o", "x/a.o", false, false},
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "x/build", true, true},
+		{"doc/*.txt", "doc/a.txt", false, true},
+		{"doc/*.txt", "doc/x/a.txt", false, false},
+		{"doc/**/*.txt", "doc/x/y/a.txt", false, true},
+		{"doc/**/*.txt", "doc/a.txt", false, true},
+		{"**/tmp", "x/y/tmp", true, true},
+		{"logs/**", "logs/a/b", false, true},
+		{"logs/**", "logs", true, false},
+		{"a?c", "abc", false, true},
+		{"a?c", "a/c", false, false},
+		{"[a-c].go", "b.go", false, true},
+		{"[!a-c].go", "b.go", false, false},
+		{"[!a-c].go", "d.go", false, true},
+		{`\#notcomment`, "#notcomment", false, true},
+		{`\!important`, "!important", false, true},
+		{`trailing\ `, "trailing ", false, true},
+		{"trailing   ", "trailing", false, true},
+		{"# comment", "# comment", false, false},
+	} {
+		rules, err := parseIgnoreRules(strings.NewReader(tc.pattern))
+		if err != nil {
+			t.Errorf("%v: %v", tc.pattern, err)
+			continue
+		}
+		ir := &ignoreRules{dir: "root", rules: rules}
+		if got, want := ir.ignored("root/"+tc.path, tc.isDir), tc.ignored; got != want {
+			t.Errorf("%q: %v: got %v, want %v", tc.pattern, tc.path, got, want)
+		}
+	}
+
+	parent := &ignoreRules{dir: "r", rules: mustParseIgnore(t, "*.log\n!keep.log\n")}
+	child := &ignoreRules{parent: parent, dir: "r/sub", rules: mustParseIgnore(t, "!*.log\nkeep.log\n")}
+	for _, tc := range []struct {
+		ir      *ignoreRules
+		path    string
+		ignored bool
+	}{
+		{parent, "r/a.log", true},
+		{parent, "r/keep.log", false},
+		{parent, "r/sub/a.log", true},
+		{child, "r/sub/a.log", false},
+		{child, "r/sub/keep.log", true},
+		{child, "r/sub/x.txt", false},
+	} {
+		if got, want := tc.ir.ignored(tc.path, false), tc.ignored; got != want {
+			t.Errorf("%v: got %v, want %v", tc.path, got, want)
+		}
+	}
+}
+
+func mustParseIgnore(t *testing.T, text string) []ignoreRule {
+	rules, err := parseIgnoreRules(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestGitignoreWalk(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	for name, contents := range map[string]string{
+		".git/info/exclude":     "secret\n",
+		".git/HEAD":             "ref\n",
+		".gitignore":            "*.o\nbuild/\n/top.txt\n",
+		"a.go":                  "",
+		"a.o":                   "",
+		"top.txt":               "",
+		"secret":                "",
+		"build/out":             "",
+		"sub/.ignore":           "!*.o\n",
+		"sub/b.o":               "",
+		"sub/top.txt":           "",
+		"sub/build/out":         "",
+		"sub/deep/.gitignore":   "*.go\n",
+		"sub/deep/c.go":         "",
+		"sub/deep/c.o":          "",
+		"other/d.go":            "",
+		"other/d.o":             "",
+		"other/.git":            "gitdir: ../.git/worktrees/other\n",
+		"sub/.git/info/exclude": "top.txt\n",
+	} {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		".gitignore", "a.go", "other", "other/.git", "other/d.go", "sub",
+		"sub/.ignore", "sub/b.o", "sub/deep", "sub/deep/.gitignore",
+		"sub/deep/c.o",
+	}
+
+	for _, sorted := range []bool{false, true} {
+		// mtime requires stat and hence the withStat code paths.
+		for _, args := range [][]string{{tmpDir}, {tmpDir, "mtime<1000d"}} {
+			lf := &locateFlags{Sorted: sorted, Depth: -1, MinDepth: 1, Gitignore: true}
+			lf.ScanSize = 2
+			fs := localfs.New()
+			var out, errs bytes.Buffer
+			o := newOutput(&out, &errs, textFormat, false)
+			v := visit{ctx: ctx, fs: fs, lf: lf, out: o, roots: []string{tmpDir}}
+			if err := (locateCmd{}).locateFS(ctx, fs, lf, v.visit, args); err != nil {
+				t.Fatal(err)
+			}
+			if errs.Len() > 0 {
+				t.Errorf("unexpected errors: %v", errs.String())
+			}
+			var matches []string
+			for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				l = strings.TrimPrefix(l, tmpDir+string(filepath.Separator))
+				matches = append(matches, filepath.ToSlash(l))
+			}
+			sort.Strings(matches)
+			if got, want := strings.Join(matches, ","), strings.Join(expected, ","); got != want {
+				t.Errorf("sorted %v, %v: got %v, want %v", sorted, args, got, want)
+			}
+		}
+	}
+}
+
+// noSuchKeyFS reports files that do not exist using an error that, as
+// for S3, does not wrap fs.ErrNotExist.
+type noSuchKeyFS struct {
+	filewalk.FS
+}
+
+var errNoSuchKey = errors.New("NoSuchKey")
+
+func (nk noSuchKeyFS) notExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return errNoSuchKey
+	}
+	return err
+}
+
+func (nk noSuchKeyFS) Lstat(ctx context.Context, path string) (file.Info, error) {
+	fi, err := nk.FS.Lstat(ctx, path)
+	return fi, nk.notExist(err)
+}
+
+func (nk noSuchKeyFS) OpenCtx(ctx context.Context, name string) (fs.File, error) {
+	f, err := nk.FS.OpenCtx(ctx, name)
+	return f, nk.notExist(err)
+}
+
+func (nk noSuchKeyFS) IsNotExist(err error) bool {
+	return errors.Is(err, errNoSuchKey)
+}
+
+func TestGitignoreNotExist(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, ".gitignore"), []byte("*.o\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	g := newGitignore(noSuchKeyFS{FS: localfs.New()})
+	ir, err := g.load(ctx, nil, tmpDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ir == nil || len(ir.rules) != 1 {
+		t.Errorf("unexpected rules: %+v", ir)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloudeng.io/file"
+)
+
+// indexMagic identifies an index file, it is followed by the version
+// of the format used.
+const (
+	indexMagic   = "ufind-index\n"
+	indexVersion = 2
+)
+
+var errCorruptIndex = errors.New("corrupt index")
+
+// indexEntry is a single file or directory recorded in an index, the
+// Sys field of its file.Info is the file.XAttr recorded for it. The
+// version of a directory is used to determine if its contents have
+// changed for file systems, such as S3, that do not maintain a
+// modification time for directories, see s3Digest. It is zero, on all
+// file systems, for directories whose contents could not all be read so
+// that they are always scanned again when the index is updated.
+type indexEntry struct {
+	path    string
+	info    file.Info
+	version uint64
+}
+
+// indexHeader records the starting locations that an index was built
+// from, when it was built and the options used to build it, so that
+// it can be updated using the same options.
+type indexHeader struct {
+	scheme       string
+	roots        []string
+	built        time.Time
+	exclusions   []string
+	excludeNames []string
+	sameDevice   bool
+}
+
+// index is the in-memory form of an index file. The entries are stored
+// in depth-first order, with the contents of each directory sorted by
+// name, such that each path shares as long a prefix as possible with
+// the one preceding it. Each path is stored as the length of the prefix
+// it shares with the preceding path followed by the remainder of the
+// path, ie. the paths are prefix compressed. The name, type, size,
+// modification time, uid/gid, user/group, device, inode and version of
+// each entry follow its path.
+type index struct {
+	indexHeader
+	entries []indexEntry
+	// paths records the location in entries of each path and children
+	// the locations of the contents of each directory.
+	paths    map[string]int
+	children map[string][]int
+}
+
+// separator returns the path separator used by the file system that
+// the index was built from.
+func (idx *index) separator() string {
+	if idx.scheme == "file" {
+		return string(filepath.Separator)
+	}
+	return "/"
+}
+
+// defaultIndexFile returns the index file used when none is specified.
+func defaultIndexFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ufind", "index"), nil
+}
+
+func appendIndexString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendIndexStrings(buf []byte, s []string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	for _, v := range s {
+		buf = appendIndexString(buf, v)
+	}
+	return buf
+}
+
+// writeIndex writes an index to w, the entries must be in the order
+// described for index.
+func writeIndex(w io.Writer, hdr indexHeader, entries []indexEntry) error {
+	wr := bufio.NewWriter(w)
+	// bufio.Writer errors are sticky and hence returned by Flush.
+	buf := append([]byte(indexMagic), indexVersion)
+	buf = appendIndexString(buf, hdr.scheme)
+	buf = binary.AppendVarint(buf, hdr.built.UnixNano())
+	buf = appendIndexStrings(buf, hdr.roots)
+	buf = appendIndexStrings(buf, hdr.exclusions)
+	buf = appendIndexStrings(buf, hdr.excludeNames)
+	sameDevice := byte(0)
+	if hdr.sameDevice {
+		sameDevice = 1
+	}
+	buf = append(buf, sameDevice)
+	buf = binary.AppendUvarint(buf, uint64(len(entries)))
+	wr.Write(buf)
+	prev := ""
+	for _, e := range entries {
+		shared := 0
+		for shared < len(prev) && shared < len(e.path) && prev[shared] == e.path[shared] {
+			shared++
+		}
+		buf = binary.AppendUvarint(buf[:0], uint64(shared))
+		buf = appendIndexString(buf, e.path[shared:])
+		buf = appendIndexString(buf, e.info.Name())
+		buf = binary.AppendUvarint(buf, uint64(e.info.Mode()))
+		buf = binary.AppendVarint(buf, e.info.Size())
+		buf = binary.AppendVarint(buf, e.info.ModTime().UnixNano())
+		xattr, _ := e.info.Sys().(file.XAttr)
+		buf = binary.AppendVarint(buf, xattr.UID)
+		buf = binary.AppendVarint(buf, xattr.GID)
+		buf = appendIndexString(buf, xattr.User)
+		buf = appendIndexString(buf, xattr.Group)
+		buf = binary.AppendUvarint(buf, xattr.Device)
+		buf = binary.AppendUvarint(buf, xattr.FileID)
+		buf = binary.AppendUvarint(buf, e.version)
+		wr.Write(buf)
+		prev = e.path
+	}
+	return wr.Flush()
+}
+
+// writeIndexFile writes an index to the named file atomically, ie. by
+// writing it to a temporary file in the same directory that is then
+// renamed, so that it may be safely read whilst being built or updated.
+func writeIndexFile(name string, hdr indexHeader, entries []indexEntry) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(name)+".tmp-")
+	if err != nil {
+		return err
+	}
+	err = writeIndex(f, hdr, entries)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// readIndexFile reads the named index file.
+func readIndexFile(name string) (*index, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := decodeIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+	return idx, nil
+}
+
+func decodeIndex(data []byte) (*index, error) {
+	if !bytes.HasPrefix(data, []byte(indexMagic)) {
+		return nil, fmt.Errorf("not an index file")
+	}
+	d := &recordDecoder{data: data[len(indexMagic):], corrupt: errCorruptIndex}
+	if v := d.uvarint(); d.err == nil && v != indexVersion {
+		return nil, fmt.Errorf("unsupported index version: %v", v)
+	}
+	var hdr indexHeader
+	hdr.scheme = d.string()
+	hdr.built = time.Unix(0, d.varint())
+	hdr.roots = d.strings()
+	hdr.exclusions = d.strings()
+	hdr.excludeNames = d.strings()
+	hdr.sameDevice = d.uvarint() == 1
+	count := d.uvarint()
+	entries := make([]indexEntry, 0, min(count, uint64(len(d.data))))
+	if d.err != nil {
+		return nil, d.err
+	}
+	prev := ""
+	for len(d.data) > 0 {
+		shared := d.uvarint()
+		if shared > uint64(len(prev)) {
+			return nil, errCorruptIndex
+		}
+		path := prev[:shared] + d.string()
+		name := d.string()
+		mode := fs.FileMode(d.uvarint())
+		size := d.varint()
+		modTime := time.Unix(0, d.varint())
+		var xattr file.XAttr
+		xattr.UID = d.varint()
+		xattr.GID = d.varint()
+		xattr.User = d.string()
+		xattr.Group = d.string()
+		xattr.Device = d.uvarint()
+		xattr.FileID = d.uvarint()
+		version := d.uvarint()
+		if d.err != nil {
+			return nil, d.err
+		}
+		entries = append(entries, indexEntry{
+			path:    path,
+			info:    file.NewInfo(name, size, mode, modTime, xattr),
+			version: version,
+		})
+		prev = path
+	}
+	if uint64(len(entries)) != count {
+		return nil, errCorruptIndex
+	}
+	return newIndex(hdr, entries), nil
+}
+
+// newIndex creates an index for entries, which must be in the order
+// described for index.
+func newIndex(hdr indexHeader, entries []indexEntry) *index {
+	idx := &index{
+		indexHeader: hdr,
+		entries:     entries,
+		paths:       make(map[string]int, len(entries)),
+		children:    map[string][]int{},
+	}
+	sep := idx.separator()
+	// dirs is the stack of directories that contain the current entry.
+	var dirs []string
+	for n, e := range entries {
+		for len(dirs) > 0 && !strings.HasPrefix(e.path, withSeparator(dirs[len(dirs)-1], sep)) {
+			dirs = dirs[:len(dirs)-1]
+		}
+		if len(dirs) > 0 {
+			parent := dirs[len(dirs)-1]
+			idx.children[parent] = append(idx.children[parent], n)
+		}
+		if e.info.IsDir() {
+			dirs = append(dirs, e.path)
+			if _, ok := idx.children[e.path]; !ok {
+				idx.children[e.path] = nil
+			}
+		}
+		idx.paths[e.path] = n
+	}
+	return idx
+}
+
+// strings decodes the strings written by appendIndexStrings.
+func (d *recordDecoder) strings() []string {
+	s := make([]string, min(d.uvarint(), uint64(len(d.data))))
+	for i := range s {
+		s[i] = d.string()
+	}
+	return s
+}
+
+// withSeparator returns dir with a trailing separator.
+func withSeparator(dir, sep string) string {
+	if strings.HasSuffix(dir, sep) {
+		return dir
+	}
+	return dir + sep
+}
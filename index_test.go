@@ -0,0 +1,490 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/localfs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestIndexFormat(t *testing.T) {
+	j := filepath.Join
+	root := j(string(filepath.Separator), "r")
+	mtime := time.Unix(1700000000, 123)
+	dir := func(path string) indexEntry {
+		return indexEntry{path: path, info: file.NewInfo(filepath.Base(path), 0, fs.ModeDir|0755, mtime, file.XAttr{UID: 1, GID: 2, Device: 3, FileID: 4}), version: uint64(len(path))}
+	}
+	reg := func(path string, size int64) indexEntry {
+		return indexEntry{path: path, info: file.NewInfo(filepath.Base(path), size, 0644, mtime, file.XAttr{UID: 5, GID: 6, User: "u", Group: "g", Device: 3, FileID: uint64(size)})}
+	}
+	entries := []indexEntry{
+		dir(root),
+		dir(j(root, "a")),
+		dir(j(root, "a", "b")),
+		reg(j(root, "a", "b", "f"), 10),
+		reg(j(root, "a", "bc"), 11),
+		dir(j(root, "a.b")),
+		reg(j(root, "a.b", "g"), 12),
+		reg(j(root, "z"), 13),
+	}
+	var buf bytes.Buffer
+	hdr := indexHeader{scheme: "file", roots: []string{root}, built: mtime, exclusions: []string{"x", "y"}, sameDevice: true}
+	if err := writeIndex(&buf, hdr, entries); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := decodeIndex(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := idx.roots, []string{root}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := idx.exclusions, hdr.exclusions; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(idx.excludeNames), 0; got != want || !idx.sameDevice {
+		t.Errorf("got %v, want %v, same device %v", got, want, idx.sameDevice)
+	}
+	if got, want := idx.built, mtime; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(idx.entries), len(entries); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, e := range entries {
+		got := idx.entries[i]
+		if got.path != e.path || got.info.Name() != e.info.Name() || got.info.Size() != e.info.Size() ||
+			got.info.Mode() != e.info.Mode() || !got.info.ModTime().Equal(e.info.ModTime()) ||
+			got.info.Sys().(file.XAttr) != e.info.Sys().(file.XAttr) || got.version != e.version {
+			t.Errorf("%v: got %v %v, want %v %v", i, got.path, got.info, e.path, e.info)
+		}
+	}
+	children := func(dir string) string {
+		var names []string
+		for _, c := range idx.children[dir] {
+			names = append(names, idx.entries[c].info.Name())
+		}
+		return strings.Join(names, ",")
+	}
+	for _, tc := range []struct {
+		dir, children string
+	}{
+		{root, "a,a.b,z"},
+		{j(root, "a"), "b,bc"},
+		{j(root, "a", "b"), "f"},
+		{j(root, "a.b"), "g"},
+	} {
+		if got, want := children(tc.dir), tc.children; got != want {
+			t.Errorf("%v: got %v, want %v", tc.dir, got, want)
+		}
+	}
+
+	// Truncated and invalid indices are detected.
+	for _, n := range []int{buf.Len() - 1, buf.Len() / 2, len(indexMagic) + 1} {
+		if _, err := decodeIndex(buf.Bytes()[:n]); err == nil {
+			t.Errorf("%v: expected an error", n)
+		}
+	}
+	if _, err := decodeIndex([]byte("not an index")); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	// An entry count that differs from the number of entries is detected.
+	var empty bytes.Buffer
+	if err := writeIndex(&empty, hdr, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := append(empty.Bytes(), buf.Bytes()[empty.Len():]...)
+	if _, err := decodeIndex(data); !errors.Is(err, errCorruptIndex) {
+		t.Errorf("got %v, want %v", err, errCorruptIndex)
+	}
+}
+
+func TestIndexUnrecorded(t *testing.T) {
+	ctx := context.Background()
+	for _, lf := range []*locateFlags{
+		{Index: "x", Printf: "%p %b", Format: textFormat},
+		{Index: "x", Printf: "%k", Format: textFormat},
+		{Index: "x", Printf: "%n", Format: textFormat},
+		{Index: "x", Top: 10, By: "-atime", Format: textFormat},
+		{Index: "x", SortBy: "size,atime", Format: textFormat},
+	} {
+		if err := lf.validate(); err == nil {
+			t.Errorf("%+v: expected an error", lf)
+		}
+	}
+	for _, lf := range []*locateFlags{
+		{Index: "x", Top: 10, By: "mtime", Format: textFormat},
+		{Index: "x", SortBy: "size,-mtime", Format: textFormat},
+		{Top: 10, By: "atime", Format: textFormat},
+		{SortBy: "atime", Format: textFormat},
+	} {
+		if err := lf.validate(); err != nil {
+			t.Errorf("%+v: unexpected error: %v", lf, err)
+		}
+	}
+	for _, expr := range []string{"atime>2d", "ctime<1h", "nlink>1", "name=f || accessed-within=1d"} {
+		lf := &locateFlags{Index: "x", Format: textFormat, Depth: -1}
+		err := (locateCmd{}).locate(ctx, lf, []string{"--", expr})
+		if err == nil || !strings.Contains(err.Error(), "cannot be used with --index") {
+			t.Errorf("%v: unexpected or missing error: %v", expr, err)
+		}
+	}
+}
+
+func TestIndexScanner(t *testing.T) {
+	idx := &index{indexHeader: indexHeader{scheme: "file"}, children: map[string][]int{"d": {0, 1, 2}}}
+	for _, name := range []string{"x", "y", "z"} {
+		idx.entries = append(idx.entries, indexEntry{path: filepath.Join("d", name), info: file.NewInfo(name, 0, 0644, time.Time{}, nil)})
+	}
+	ctx := context.Background()
+	ifs := newIndexFS(idx)
+	sc := ifs.LevelScanner("d")
+	var batches []string
+	for sc.Scan(ctx, 2) {
+		var names []string
+		for _, e := range sc.Contents() {
+			names = append(names, e.Name)
+		}
+		batches = append(batches, strings.Join(names, ","))
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(batches, ";"), "x,y;z"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	sc = ifs.LevelScanner("nowhere")
+	if sc.Scan(ctx, 2) || !ifs.IsNotExist(sc.Err()) {
+		t.Errorf("expected a not exist error: %v", sc.Err())
+	}
+	if _, err := ifs.Open("d/x"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+// buildTestIndex builds an index for the test tree and returns an
+// indexFS for it.
+func buildTestIndex(ctx context.Context, t *testing.T) *indexFS {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "index")
+	buildIndexFile(ctx, t, name, localTestTree, nil)
+	idx, err := readIndexFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newIndexFS(idx)
+}
+
+// buildIndexFile builds, or updates if old is not nil, the named index
+// for root and returns the indexBuilder used.
+func buildIndexFile(ctx context.Context, t *testing.T, name, root string, old *index) *indexBuilder {
+	t.Helper()
+	ib := newIndexBuilder(localfs.New(), nil, newOutput(&bytes.Buffer{}, &bytes.Buffer{}, textFormat, false), newOutcome(nil))
+	ib.old = old
+	hdr := indexHeader{scheme: "file", roots: []string{root}, built: time.Now()}
+	entries, err := ib.build(ctx, WalkerFlags{}, false, hdr.roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeIndexFile(name, hdr, entries); err != nil {
+		t.Fatal(err)
+	}
+	return ib
+}
+
+func TestIndexGroups(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "index")
+	buildIndexFile(ctx, t, name, localTestTree, nil)
+	groups, err := indexGroups(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filesystems fileSystems
+	walked, err := filesystems.group(ctx, []string{localTestTree})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Failures of --exec and --delete are mapped back to the group's
+	// file system using the scheme of the failing path.
+	if got, want := groups[0].scheme, walked[0].scheme; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestIndexQuery(t *testing.T) {
+	ctx := context.Background()
+	ifs := buildTestIndex(ctx, t)
+	for _, sorted := range []bool{false, true} {
+		for _, expr := range []string{
+			"",
+			"type=f",
+			"type=d",
+			"type=l",
+			"re=a0$ || re=b0.1$",
+			"newer=2010-12-13 && file-larger=2",
+			"name=f0 && user=" + testTreeUID(t),
+		} {
+			lf := &locateFlags{Sorted: sorted, Depth: -1}
+			lf.ScanSize = 2
+			args := []string{localTestTree, "--", expr}
+			want, _ := locate(ctx, t, lf, args...)
+
+			collect := &collector{}
+			if err := (locateCmd{}).locateFS(ctx, ifs, lf, collect.visit, args); err != nil {
+				t.Fatal(err)
+			}
+			sortFound(collect.found)
+			if len(collect.errs) > 0 {
+				t.Errorf("sorted %v, %q: unexpected errors: %v", sorted, expr, collect.errs)
+			}
+			cmpFound(t, collect.found, want)
+		}
+	}
+}
+
+// testTreeUID returns the uid of the owner of the test tree.
+func testTreeUID(t *testing.T) string {
+	fi, err := os.Stat(localTestTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xattr, err := localfs.New().XAttr(context.Background(), localTestTree, file.NewInfoFromFileInfo(fi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.FormatInt(xattr.UID, 10)
+}
+
+func TestIndexUpdate(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	j := filepath.Join
+	for _, dir := range []string{j("a", "a1"), j("b", "b1"), "c"} {
+		if err := os.MkdirAll(j(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{j("a", "a1", "f"), j("a", "f"), j("b", "b1", "f"), j("c", "f")} {
+		if err := os.WriteFile(j(root, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Make the directories appear to have been last modified well
+	// before the index is built.
+	then := time.Now().Add(-time.Hour)
+	for _, dir := range []string{"", "a", j("a", "a1"), "b", j("b", "b1"), "c"} {
+		if err := os.Chtimes(j(root, dir), then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := j(tmp, "index")
+	if ib := buildIndexFile(ctx, t, name, root, nil); ib.unchanged.Load() != 0 || ib.rescanned.Load() != 6 {
+		t.Errorf("unexpected counts: %v %v", ib.unchanged.Load(), ib.rescanned.Load())
+	}
+	old, err := readIndexFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Modifies a and b and hence only they should be rescanned.
+	if err := os.WriteFile(j(root, "a", "new"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(j(root, "b", "b1")); err != nil {
+		t.Fatal(err)
+	}
+	ib := buildIndexFile(ctx, t, name, root, old)
+	if got, want := ib.unchanged.Load(), int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := ib.rescanned.Load(), int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	updated, err := readIndexFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt := j(tmp, "rebuilt")
+	buildIndexFile(ctx, t, rebuilt, root, nil)
+	want, err := readIndexFile(rebuilt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := func(idx *index) string {
+		var p []string
+		for _, e := range idx.entries {
+			rel, _ := filepath.Rel(root, e.path)
+			p = append(p, fmt.Sprintf("%v:%v:%v", rel, e.info.Size(), e.info.ModTime().UnixNano()))
+		}
+		return strings.Join(p, " ")
+	}
+	if got, want := paths(updated), paths(want); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := updated.paths[j(root, "b", "b1", "f")]; ok {
+		t.Errorf("deleted directory is still in the index")
+	}
+
+	// Only the index files remain, ie. the temporary files used to
+	// write them atomically have been renamed.
+	des, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, de := range des {
+		names = append(names, de.Name())
+	}
+	if got, want := strings.Join(names, ","), "index,rebuilt,root"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestIndexUpdateUnreadable(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	dir := filepath.Join(root, "dir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "f"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(dir, 0755) })
+	if _, err := os.ReadDir(dir); err == nil {
+		t.Skip("unreadable directories are readable, eg. when running as root")
+	}
+	then := time.Now().Add(-time.Hour)
+	for _, d := range []string{root, dir} {
+		if err := os.Chtimes(d, then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := filepath.Join(tmp, "index")
+	buildIndexFile(ctx, t, name, root, nil)
+	old, err := readIndexFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := old.paths[dir]; !ok || old.entries[n].version != 0 {
+		t.Fatalf("unreadable directory is missing or has a version")
+	}
+
+	// Making the directory readable does not change its modification
+	// time but it must still be rescanned.
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ib := buildIndexFile(ctx, t, name, root, old)
+	if got, want := ib.rescanned.Load(), int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	updated, err := readIndexFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := updated.paths[filepath.Join(dir, "f")]; !ok {
+		t.Errorf("contents of the previously unreadable directory are missing from the index")
+	}
+}
+
+type listerFunc func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+
+func (fn listerFunc) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return fn(ctx, params, optFns...)
+}
+
+func TestS3Versioner(t *testing.T) {
+	ctx := context.Background()
+	mtime := time.Now()
+	size := int64(1)
+	var prefixes []string
+	lister := listerFunc(func(_ context.Context, params *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+		if got, want := aws.ToString(params.Prefix), "dir/"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if aws.ToString(params.ContinuationToken) == "" {
+			return &s3.ListObjectsV2Output{
+				Contents:              []types.Object{{Key: aws.String("dir/f"), Size: aws.Int64(size), LastModified: &mtime}},
+				IsTruncated:           aws.Bool(true),
+				NextContinuationToken: aws.String("next"),
+			}, nil
+		}
+		out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+		for _, p := range prefixes {
+			out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(p)})
+		}
+		return out, nil
+	})
+	v := &s3Versioner{client: lister}
+	version := func() uint64 {
+		n, err := v.version(ctx, "s3://bucket/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Errorf("zero version")
+		}
+		return n
+	}
+	v1 := version()
+	if got := version(); got != v1 {
+		t.Errorf("got %v, want %v", got, v1)
+	}
+	prefixes = []string{"dir/sub/"}
+	v2 := version()
+	size = 2
+	v3 := version()
+	if v1 == v2 || v2 == v3 || v1 == v3 {
+		t.Errorf("versions should differ: %v %v %v", v1, v2, v3)
+	}
+
+	// The version of a directory computed from its contents, as returned
+	// by stat when it is scanned, in any order, is the same as that
+	// computed by listing it.
+	ib := newIndexBuilder(nil, nil, nil, nil)
+	ib.versioner = v
+	ib.addVersion("s3://bucket/dir", file.InfoList{
+		file.NewInfo("sub/", 0, fs.ModeDir, time.Time{}, nil),
+	}, nil)
+	ib.addVersion("s3://bucket/dir", file.InfoList{
+		file.NewInfo("f", size, 0, mtime.Truncate(time.Second), nil),
+	}, nil)
+	if got, want := ib.version("s3://bucket/dir"), v3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// A directory whose contents could not all be read has no version.
+	ib.addVersion("s3://bucket/dir", nil, errors.New("oops"))
+	if got, want := ib.version("s3://bucket/dir"), uint64(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := v.version(ctx, "/local/path"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
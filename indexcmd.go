@@ -0,0 +1,353 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloudeng.io/cmdutil/flags"
+	"cloudeng.io/file"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/asyncstat"
+	"cloudeng.io/path/cloudpath"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type indexCmd struct{}
+
+type indexBuildFlags struct {
+	WalkerFlags
+	Index        string          `subcmd:"index,,'the index file to create, the default is ufind/index in the user cache directory'"`
+	Exclusions   flags.Repeating `subcmd:"exclude,,exclude directories matching the specified regexp patterns"`
+	ExcludeNames flags.Repeating `subcmd:"exclude-name,,'exclude directories whose name matches the specified glob patterns, eg. vendor'"`
+	SameDevice   bool            `subcmd:"same-device,true,only index directories on the same device as the starting directory"`
+	QuietErrors  string          `subcmd:"quiet-errors,,'comma separated list of the classes of error that are not displayed as they are encountered, as per locate --quiet-errors'"`
+}
+
+type indexUpdateFlags struct {
+	WalkerFlags
+	Index       string `subcmd:"index,,'the index file to update, the default is ufind/index in the user cache directory'"`
+	QuietErrors string `subcmd:"quiet-errors,,'comma separated list of the classes of error that are not displayed as they are encountered, as per locate --quiet-errors'"`
+}
+
+// indexFile returns name, or the default index file if name is empty.
+func indexFile(name string) (string, error) {
+	if len(name) > 0 {
+		return name, nil
+	}
+	return defaultIndexFile()
+}
+
+// indexGroups returns the single group of starting locations used to
+// search an index, the starting locations default to those that the
+// index was built from. The group is keyed by the cloudpath scheme of
+// the starting locations, as per fileSystems.group, rather than by that
+// of the file system the index was built from, so that the paths found
+// can be mapped back to it.
+func indexGroups(name string, roots []string) ([]*rootGroup, error) {
+	idx, err := readIndexFile(name)
+	if err != nil {
+		return nil, err
+	}
+	ifs := newIndexFS(idx)
+	if len(roots) == 0 {
+		roots = idx.roots
+	}
+	roots = absoluteRoots(ifs, roots)
+	scheme := idx.scheme
+	if len(roots) > 0 {
+		scheme = cloudpath.DefaultMatchers.Match(roots[0]).Scheme
+	}
+	return []*rootGroup{{scheme: scheme, fs: ifs, roots: roots}}, nil
+}
+
+func (ic indexCmd) build(ctx context.Context, values interface{}, args []string) error {
+	bf := values.(*indexBuildFlags)
+	if len(args) == 0 {
+		return fmt.Errorf("no directories specified")
+	}
+	name, err := indexFile(bf.Index)
+	if err != nil {
+		return err
+	}
+	quiet, err := parseErrorClasses(bf.QuietErrors)
+	if err != nil {
+		return err
+	}
+	hdr := indexHeader{
+		roots:        args,
+		exclusions:   bf.Exclusions.Values,
+		excludeNames: bf.ExcludeNames.Values,
+		sameDevice:   bf.SameDevice,
+	}
+	return ic.index(ctx, name, hdr, nil, bf.WalkerFlags, quiet)
+}
+
+func (ic indexCmd) update(ctx context.Context, values interface{}, _ []string) error {
+	uf := values.(*indexUpdateFlags)
+	name, err := indexFile(uf.Index)
+	if err != nil {
+		return err
+	}
+	quiet, err := parseErrorClasses(uf.QuietErrors)
+	if err != nil {
+		return err
+	}
+	old, err := readIndexFile(name)
+	if err != nil {
+		return err
+	}
+	return ic.index(ctx, name, old.indexHeader, old, uf.WalkerFlags, quiet)
+}
+
+// index builds the named index for the starting locations and options
+// in hdr, reusing the contents of unchanged directories recorded in old
+// if it is not nil.
+func (ic indexCmd) index(ctx context.Context, name string, hdr indexHeader, old *index, wf WalkerFlags, quiet map[string]bool) error {
+	out := newOutput(os.Stdout, os.Stderr, textFormat, false)
+	ib, hdr, entries, err := buildIndex(ctx, hdr, old, wf, out, newOutcome(quiet))
+	if err != nil {
+		return err
+	}
+	if err := writeIndexFile(name, hdr, entries); err != nil {
+		return err
+	}
+	if old != nil {
+		out.message("%v: %v entries, %v directories unchanged, %v rescanned", name, len(entries), ib.unchanged.Load(), ib.rescanned.Load())
+	}
+	ib.outcome.summarize(out)
+	return ib.outcome.err()
+}
+
+// buildIndex walks the starting locations in hdr, using the options
+// recorded in it, and returns the entries for the resulting index along
+// with its completed header.
+func buildIndex(ctx context.Context, hdr indexHeader, old *index, wf WalkerFlags, out *output, oc *outcome) (*indexBuilder, indexHeader, []indexEntry, error) {
+	exclude, err := newExclusions(hdr.exclusions, nil, hdr.excludeNames, nil)
+	if err != nil {
+		return nil, hdr, nil, err
+	}
+	var filesystems fileSystems
+	groups, err := filesystems.group(ctx, hdr.roots)
+	if err != nil {
+		return nil, hdr, nil, err
+	}
+	if len(groups) != 1 {
+		return nil, hdr, nil, fmt.Errorf("the directories to be indexed must all be on the same file system")
+	}
+	wkfs := groups[0].fs
+	hdr.scheme = wkfs.Scheme()
+	hdr.roots = absoluteRoots(wkfs, uniqueRoots(wkfs, groups[0].roots))
+	ib := newIndexBuilder(wkfs, exclude, out, oc)
+	ib.old = old
+	if groups[0].scheme == "s3" {
+		cfg, err := filesystems.awsConfig(ctx)
+		if err != nil {
+			return nil, hdr, nil, err
+		}
+		ib.versioner = &s3Versioner{client: s3.NewFromConfig(cfg)}
+	}
+	hdr.built = time.Now()
+	entries, err := ib.build(ctx, wf, hdr.sameDevice, hdr.roots)
+	return ib, hdr, entries, err
+}
+
+func (ic indexCmd) query(ctx context.Context, values interface{}, args []string) error {
+	lf := values.(*locateFlags)
+	name, err := indexFile(lf.Index)
+	if err != nil {
+		return err
+	}
+	lf.Index = name
+	return locateCmd{}.locate(ctx, lf, append([]string{"--"}, args...))
+}
+
+// indexBuilder implements filewalk.Handler to record every file and
+// directory, along with the information returned by lstat, below
+// the starting locations. The state for each directory is its depth.
+// When updating an index, the contents of directories that are unchanged
+// since the index was built are copied from it rather than being
+// scanned again. Note that for local file systems this includes the
+// information recorded for files that have since been edited in place,
+// or had their permissions or owner changed, since doing so does not
+// change the modification time of their directory.
+type indexBuilder struct {
+	fs           filewalk.FS
+	stats        *asyncstat.T
+	exclude      *exclusions
+	isSameDevice sameDevice
+	out          *output
+	outcome      *outcome
+	// old is the index being updated, if any, and versioner is used
+	// to determine if the directories of file systems that require it
+	// have changed since old was built.
+	old       *index
+	versioner *s3Versioner
+	// pending records the depth of each directory that is yet to be
+	// walked.
+	pending sync.Map
+
+	unchanged, rescanned atomic.Int64
+
+	mu sync.Mutex
+	// contents records the contents of each directory, the starting
+	// locations are recorded as the contents of "".
+	contents map[string][]indexEntry
+	// versions records the versions of directories for file systems
+	// that require them and unversioned the directories, on any file
+	// system, whose contents could not all be read, see addVersion.
+	versions    map[string]s3Digest
+	unversioned map[string]bool
+}
+
+func newIndexBuilder(wkfs filewalk.FS, exclude *exclusions, out *output, oc *outcome) *indexBuilder {
+	return &indexBuilder{
+		fs:          wkfs,
+		exclude:     exclude,
+		out:         out,
+		outcome:     oc,
+		contents:    map[string][]indexEntry{},
+		versions:    map[string]s3Digest{},
+		unversioned: map[string]bool{},
+	}
+}
+
+// build walks the starting locations and returns the entries to be
+// written to the index, in the order required by writeIndex.
+func (ib *indexBuilder) build(ctx context.Context, wf WalkerFlags, isSameDevice bool, roots []string) ([]indexEntry, error) {
+	fwo, aso := wf.walkOptions(false)
+	ib.stats = asyncstat.New(ib.fs, aso...)
+	if isSameDevice {
+		sd, err := newSameDevice(ctx, ib.fs, roots...)
+		if err != nil {
+			return nil, err
+		}
+		ib.isSameDevice = sd
+	}
+	if err := filewalk.New(ib.fs, ib, fwo...).Walk(ctx, roots...); err != nil {
+		return nil, err
+	}
+	var entries []indexEntry
+	var appendDir func(dir string)
+	appendEntry := func(e indexEntry) {
+		if !e.info.IsDir() {
+			entries = append(entries, e)
+			return
+		}
+		e.version = ib.version(e.path)
+		entries = append(entries, e)
+		appendDir(e.path)
+	}
+	appendDir = func(dir string) {
+		contents := ib.contents[dir]
+		sort.Slice(contents, func(i, j int) bool {
+			return contents[i].info.Name() < contents[j].info.Name()
+		})
+		for _, e := range contents {
+			appendEntry(e)
+		}
+	}
+	for _, e := range ib.contents[""] {
+		appendEntry(e)
+	}
+	return entries, nil
+}
+
+func (ib *indexBuilder) error(path string, err error) {
+	if ib.outcome.error(classifyError(ib.fs, err), path) {
+		ib.out.error(path, err)
+	}
+}
+
+// add records an entry, with its extended attributes, as part of the
+// contents of parent.
+func (ib *indexBuilder) add(ctx context.Context, parent, path string, fi file.Info) {
+	xattr, err := ib.fs.XAttr(ctx, path, fi)
+	if err != nil {
+		ib.error(path, err)
+	}
+	e := indexEntry{
+		path: path,
+		info: file.NewInfo(fi.Name(), fi.Size(), fi.Mode(), fi.ModTime(), xattr),
+	}
+	ib.outcome.matched()
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	ib.contents[parent] = append(ib.contents[parent], e)
+}
+
+func (ib *indexBuilder) Prefix(ctx context.Context, depth *int, prefix string, fi file.Info, err error) (bool, file.InfoList, error) {
+	d, ok := ib.pending.LoadAndDelete(prefix)
+	if ok {
+		*depth = d.(int)
+	}
+	if err != nil {
+		ib.error(prefix, err)
+		ib.addVersion(prefix, nil, err)
+		return true, nil, nil
+	}
+	if !ok {
+		// Only the starting locations have no pending state.
+		ib.add(ctx, "", prefix, fi)
+		if !fi.IsDir() {
+			return true, nil, nil
+		}
+	}
+	if ib.exclude.Match(prefix, *depth) {
+		return true, nil, nil
+	}
+	same, err := ib.isSameDevice.Match(ctx, ib.fs, prefix, fi)
+	if err != nil {
+		ib.error(prefix, err)
+		ib.addVersion(prefix, nil, err)
+		return true, nil, nil
+	}
+	if !same {
+		return true, nil, nil
+	}
+	unchanged, err := ib.isUnchanged(ctx, prefix, fi)
+	if err != nil {
+		ib.error(prefix, err)
+		ib.addVersion(prefix, nil, err)
+		return true, nil, nil
+	}
+	if !unchanged {
+		ib.rescanned.Add(1)
+		return false, nil, nil
+	}
+	// Avoid scanning the directory when it has no subdirectories
+	// by stopping, rather than returning no children.
+	children := ib.reuse(ctx, *depth, prefix)
+	return len(children) == 0, children, nil
+}
+
+func (ib *indexBuilder) Contents(ctx context.Context, depth *int, prefix string, contents []filewalk.Entry) (file.InfoList, error) {
+	children, all, err := ib.stats.Process(ctx, prefix, contents)
+	if err != nil {
+		ib.error(prefix, err)
+	}
+	ib.addVersion(prefix, all, err)
+	for _, info := range all {
+		ib.add(ctx, prefix, ib.fs.Join(prefix, info.Name()), info)
+	}
+	for _, c := range children {
+		ib.pending.Store(ib.fs.Join(prefix, c.Name()), *depth+1)
+	}
+	return children, nil
+}
+
+func (ib *indexBuilder) Done(_ context.Context, _ *int, prefix string, err error) error {
+	if err != nil {
+		ib.error(prefix, err)
+		ib.addVersion(prefix, nil, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/path/cloudpath"
+)
+
+// errNotIndexed is returned for operations, such as reading the contents
+// of a file, that require information that is not recorded in an index.
+var errNotIndexed = errors.New("not recorded in the index")
+
+// indexFS implements filewalk.FS using an index so that expressions are
+// evaluated against the index rather than the file system it was built
+// from. Symbolic links are never followed, ie. Stat is the same as Lstat.
+type indexFS struct {
+	idx *index
+}
+
+func newIndexFS(idx *index) *indexFS {
+	return &indexFS{idx: idx}
+}
+
+func (ifs *indexFS) Scheme() string {
+	return ifs.idx.scheme
+}
+
+func (ifs *indexFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: errNotIndexed}
+}
+
+func (ifs *indexFS) OpenCtx(_ context.Context, name string) (fs.File, error) {
+	return ifs.Open(name)
+}
+
+func (ifs *indexFS) Readlink(_ context.Context, path string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: path, Err: errNotIndexed}
+}
+
+func (ifs *indexFS) Stat(ctx context.Context, path string) (file.Info, error) {
+	return ifs.Lstat(ctx, path)
+}
+
+func (ifs *indexFS) Lstat(_ context.Context, path string) (file.Info, error) {
+	n, ok := ifs.idx.paths[path]
+	if !ok {
+		return file.Info{}, &fs.PathError{Op: "lstat", Path: path, Err: fs.ErrNotExist}
+	}
+	return ifs.idx.entries[n].info, nil
+}
+
+func (ifs *indexFS) Join(components ...string) string {
+	if ifs.idx.scheme == "file" {
+		return filepath.Join(components...)
+	}
+	return cloudpath.Join('/', components)
+}
+
+func (ifs *indexFS) Base(p string) string {
+	if ifs.idx.scheme == "file" {
+		return filepath.Base(p)
+	}
+	return path.Base(p)
+}
+
+func (ifs *indexFS) IsPermissionError(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
+
+func (ifs *indexFS) IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// XAttr implements file.FS, the extended attributes are those recorded
+// in the index.
+func (ifs *indexFS) XAttr(ctx context.Context, path string, fi file.Info) (file.XAttr, error) {
+	if xattr, ok := fi.Sys().(file.XAttr); ok {
+		return xattr, nil
+	}
+	fi, err := ifs.Lstat(ctx, path)
+	if err != nil {
+		return file.XAttr{}, err
+	}
+	xattr, _ := fi.Sys().(file.XAttr)
+	return xattr, nil
+}
+
+// SysXAttr implements file.FS, the system specific representation of
+// the extended attributes is file.XAttr itself.
+func (ifs *indexFS) SysXAttr(_ any, merge file.XAttr) any {
+	return merge
+}
+
+func (ifs *indexFS) LevelScanner(path string) filewalk.LevelScanner {
+	children, ok := ifs.idx.children[path]
+	if !ok {
+		return &indexScanner{err: &fs.PathError{Op: "scan", Path: path, Err: fs.ErrNotExist}}
+	}
+	return &indexScanner{idx: ifs.idx, children: children}
+}
+
+// indexScanner implements filewalk.LevelScanner for a directory in an
+// index.
+type indexScanner struct {
+	idx      *index
+	children []int
+	contents []filewalk.Entry
+	err      error
+}
+
+func (s *indexScanner) Scan(ctx context.Context, n int) bool {
+	if s.err != nil || len(s.children) == 0 {
+		return false
+	}
+	if err := ctx.Err(); err != nil {
+		s.err = err
+		return false
+	}
+	n = min(max(n, 1), len(s.children))
+	s.contents = make([]filewalk.Entry, n)
+	for i, c := range s.children[:n] {
+		info := s.idx.entries[c].info
+		s.contents[i] = filewalk.Entry{Name: info.Name(), Type: info.Mode().Type()}
+	}
+	s.children = s.children[n:]
+	return true
+}
+
+func (s *indexScanner) Contents() []filewalk.Entry {
+	return s.contents
+}
+
+func (s *indexScanner) Err() error {
+	return s.err
+}
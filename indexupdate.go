@@ -0,0 +1,211 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"strings"
+	"time"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/filewalk"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// mtimeGranularity is the coarsest granularity of the modification
+// times maintained by the supported local file systems, eg. FAT's two
+// seconds.
+const mtimeGranularity = 2 * time.Second
+
+// isUnchanged returns true if the contents of the directory prefix are
+// unchanged since the index being updated was built. A directory whose
+// contents could not all be read when the index was built, ie. one with
+// no version, is always considered to have changed since the failure
+// need not have changed its modification time. For file systems that
+// require it, the version of an unchanged directory is recorded since it
+// is not scanned again.
+func (ib *indexBuilder) isUnchanged(ctx context.Context, prefix string, fi file.Info) (bool, error) {
+	if ib.old == nil {
+		return false, nil
+	}
+	n, ok := ib.old.paths[prefix]
+	if !ok || !ib.old.entries[n].info.IsDir() {
+		return false, nil
+	}
+	old := ib.old.entries[n]
+	if old.version == 0 {
+		return false, nil
+	}
+	if ib.versioner != nil {
+		v, err := ib.versioner.version(ctx, prefix)
+		if err != nil || v != old.version {
+			return false, err
+		}
+		ib.mu.Lock()
+		ib.versions[prefix] = s3Digest(v)
+		ib.mu.Unlock()
+		return true, nil
+	}
+	// A directory that was modified within the granularity of its
+	// modification time of the index being built may have been
+	// modified again, without its modification time changing, after
+	// it was scanned.
+	mtime := fi.ModTime()
+	return !mtime.IsZero() && mtime.Equal(old.info.ModTime()) &&
+		mtime.Before(ib.old.built.Add(-mtimeGranularity)), nil
+}
+
+// addVersion adds the contents of the directory prefix, as scanned, to
+// its version for file systems that require it. A directory whose
+// contents could not all be read, on any file system, has no version
+// and hence is always scanned again when the index is updated.
+func (ib *indexBuilder) addVersion(prefix string, contents file.InfoList, err error) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if err != nil {
+		ib.unversioned[prefix] = true
+		return
+	}
+	if ib.versioner == nil {
+		return
+	}
+	d := ib.versions[prefix]
+	for _, info := range contents {
+		d.add(info.Name(), info.Size(), info.ModTime())
+	}
+	ib.versions[prefix] = d
+}
+
+// version returns the version of the directory prefix, or zero if it
+// has none. The version of directories on file systems that maintain
+// modification times is always one, it records only that the directory
+// was read successfully.
+func (ib *indexBuilder) version(prefix string) uint64 {
+	if ib.unversioned[prefix] {
+		return 0
+	}
+	return ib.versions[prefix].version()
+}
+
+// reuse records the contents of the unchanged directory prefix as they
+// are recorded in the index being updated and returns its
+// subdirectories. Local subdirectories are lstat'ed again since their
+// current modification times are needed to determine if they in turn
+// have changed.
+func (ib *indexBuilder) reuse(ctx context.Context, depth int, prefix string) file.InfoList {
+	ib.unchanged.Add(1)
+	var contents []indexEntry
+	var dirs []filewalk.Entry
+	var children file.InfoList
+	for _, c := range ib.old.children[prefix] {
+		e := ib.old.entries[c]
+		switch {
+		case !e.info.IsDir():
+			contents = append(contents, e)
+		case ib.versioner != nil:
+			contents = append(contents, e)
+			children = append(children, e.info)
+		default:
+			dirs = append(dirs, filewalk.Entry{Name: e.info.Name(), Type: fs.ModeDir})
+		}
+	}
+	if len(dirs) > 0 {
+		var all file.InfoList
+		var err error
+		children, all, err = ib.stats.Process(ctx, prefix, dirs)
+		if err != nil {
+			ib.error(prefix, err)
+		}
+		for _, info := range all {
+			ib.add(ctx, prefix, ib.fs.Join(prefix, info.Name()), info)
+		}
+	}
+	for range contents {
+		ib.outcome.matched()
+	}
+	ib.mu.Lock()
+	ib.contents[prefix] = append(ib.contents[prefix], contents...)
+	ib.mu.Unlock()
+	for _, c := range children {
+		ib.pending.Store(ib.fs.Join(prefix, c.Name()), depth+1)
+	}
+	return children
+}
+
+// s3Lister represents the subset of the S3 API used to determine the
+// version of a directory.
+type s3Lister interface {
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// s3Digest is the digest of the contents of an S3 directory, ie. a
+// prefix, namely the sum of the digests of the name, size and
+// modification time of every object, and the name of every prefix, that
+// it immediately contains. The digest is a sum so that it does not
+// depend on the order in which the contents are listed and the
+// modification times are truncated to seconds since those returned
+// when listing objects are more precise than those returned by stat.
+type s3Digest uint64
+
+func (d *s3Digest) add(name string, size int64, mtime time.Time) {
+	h := fnv.New64a()
+	var secs int64
+	if !mtime.IsZero() {
+		secs = mtime.Unix()
+	}
+	fmt.Fprintf(h, "%s\x00%d\x00%d", name, size, secs)
+	*d += s3Digest(h.Sum64())
+}
+
+// version returns the version corresponding to the digest, which is
+// never zero.
+func (d s3Digest) version() uint64 {
+	return max(uint64(d), 1)
+}
+
+// s3Versioner determines the version of S3 directories, ie. prefixes,
+// since they have no modification time, by listing them. It is used only
+// when updating an index to determine if a directory has changed, the
+// version of a directory that is scanned is computed from its contents
+// as they are scanned, see s3Digest.
+type s3Versioner struct {
+	client s3Lister
+}
+
+func (v *s3Versioner) version(ctx context.Context, path string) (uint64, error) {
+	bucket, key, err := s3BucketAndKey(path)
+	if err != nil {
+		return 0, err
+	}
+	prefix := s3Prefix(key)
+	var d s3Digest
+	var token *string
+	for {
+		objs, err := v.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            aws.String(bucket),
+			Prefix:            aws.String(prefix),
+			Delimiter:         aws.String("/"),
+			ContinuationToken: token,
+		})
+		if err != nil {
+			return 0, err
+		}
+		for _, obj := range objs.Contents {
+			d.add(strings.TrimPrefix(aws.ToString(obj.Key), prefix), aws.ToInt64(obj.Size), aws.ToTime(obj.LastModified))
+		}
+		for _, p := range objs.CommonPrefixes {
+			d.add(strings.TrimPrefix(aws.ToString(p.Prefix), prefix), 0, time.Time{})
+		}
+		if !aws.ToBool(objs.IsTruncated) {
+			break
+		}
+		token = objs.NextContinuationToken
+	}
+	return d.version(), nil
+}
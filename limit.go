@@ -0,0 +1,85 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// errNoMatches is returned when no matches were found so that the exit
+// status reflects whether anything was found.
+var errNoMatches = errors.New("no matches found")
+
+// matchLimit stops a search, by canceling the context used for the
+// walk, once the specified number of matches have been reported.
+type matchLimit struct {
+	limit  int64
+	count  atomic.Int64
+	cancel context.CancelFunc
+}
+
+// newMatchLimit returns a matchLimit and the context to be used for
+// the walk that it is to stop.
+func newMatchLimit(ctx context.Context, limit int) (context.Context, *matchLimit) {
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, &matchLimit{limit: int64(limit), cancel: cancel}
+}
+
+// take returns true if another match may be reported, the walk is
+// canceled once the limit is reached.
+func (ml *matchLimit) take() bool {
+	if ml == nil {
+		return true
+	}
+	n := ml.count.Add(1)
+	if n == ml.limit {
+		ml.cancel()
+	}
+	return n <= ml.limit
+}
+
+// reached returns true if the limit has been reached and hence the
+// walk canceled.
+func (ml *matchLimit) reached() bool {
+	return ml != nil && ml.count.Load() >= ml.limit
+}
+
+// found returns the number of matches reported.
+func (ml *matchLimit) found() int64 {
+	return min(ml.count.Load(), ml.limit)
+}
+
+// walkDone returns the error to report for a walk, if any, taking into
+// account that the walk will have been canceled if the limit was reached,
+// in which case only the errors that are due to that cancelation are
+// discarded.
+func (ml *matchLimit) walkDone(err error) error {
+	ml.cancel()
+	if !ml.reached() {
+		return err
+	}
+	return withoutCanceled(err)
+}
+
+// withoutCanceled returns err without any of the errors, including
+// those joined using errors.Join, that are due to context.Canceled.
+func withoutCanceled(err error) error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, err := range joined.Unwrap() {
+			errs = append(errs, withoutCanceled(err))
+		}
+		return errors.Join(errs...)
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cloudeng.io/file/filewalk/filewalktestutil"
+)
+
+func TestLimit(t *testing.T) {
+	ctx := context.Background()
+	spec, ordered := yamlForMockFS("root", 10, 4, []string{"root"})
+	fs, err := filewalktestutil.NewMockFS("root", filewalktestutil.WithYAMLConfig(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sorted := range []bool{false, true} {
+		for _, limit := range []int{1, 7, 100} {
+			lf := &locateFlags{Sorted: sorted, Depth: -1, Limit: limit}
+			lf.ScanSize = 5
+			var out, errs bytes.Buffer
+			o := newOutput(&out, &errs, textFormat, false)
+			v := visit{ctx: ctx, fs: fs, lf: lf, out: o, roots: []string{"root"}}
+			walkCtx, ml := newMatchLimit(ctx, limit)
+			v.limit = ml
+			err := (locateCmd{}).locateFS(walkCtx, fs, lf, v.visit, []string{"root"})
+			if err := ml.walkDone(err); err != nil {
+				t.Errorf("sorted %v, limit %v: %v", sorted, limit, err)
+			}
+			if errs.Len() > 0 {
+				t.Errorf("sorted %v, limit %v: unexpected errors: %v", sorted, limit, errs.String())
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if got, want := len(lines), limit; got != want {
+				t.Errorf("sorted %v: got %v, want %v", sorted, got, want)
+			}
+			if got, want := ml.found(), int64(limit); got != want {
+				t.Errorf("sorted %v: got %v, want %v", sorted, got, want)
+			}
+			if !sorted {
+				continue
+			}
+			if got, want := strings.Join(lines, ","), strings.Join(ordered[:limit], ","); got != want {
+				t.Errorf("limit %v: got %v, want %v", limit, got, want)
+			}
+			// At most the remainder of the current batch is evaluated
+			// once the limit is reached.
+			if got, want := ml.count.Load(), int64(limit+lf.ScanSize); got > want {
+				t.Errorf("limit %v: too many matches evaluated: %v > %v", limit, got, want)
+			}
+		}
+	}
+
+	// No matches, the walk completes without being canceled.
+	walkCtx, ml := newMatchLimit(ctx, 1)
+	lf := &locateFlags{Depth: -1, Limit: 1}
+	var out bytes.Buffer
+	v := visit{ctx: ctx, fs: fs, lf: lf, out: newOutput(&out, &out, textFormat, false), roots: []string{"root"}, limit: ml}
+	err = (locateCmd{}).locateFS(walkCtx, fs, lf, v.visit, []string{"root", "name=nomatch"})
+	if err := ml.walkDone(err); err != nil || ml.found() != 0 || out.Len() != 0 {
+		t.Errorf("unexpected error, matches or output: %v, %v, %v", err, ml.found(), out.String())
+	}
+
+	// Errors other than cancelation are returned.
+	ml.count.Store(1)
+	if err := ml.walkDone(errors.New("oops")); err == nil || err.Error() != "oops" {
+		t.Errorf("unexpected or missing error: %v", err)
+	}
+	if err := ml.walkDone(context.Canceled); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	oops := errors.New("oops")
+	err = errors.Join(fmt.Errorf("scan: %w", context.Canceled), errors.Join(oops, context.Canceled))
+	if err := ml.walkDone(err); !errors.Is(err, oops) || errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected or missing error: %v", err)
+	}
+}
@@ -6,20 +6,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"time"
 
-	"cloudeng.io/aws/awsconfig"
-	"cloudeng.io/aws/s3fs"
 	"cloudeng.io/cmdutil/flags"
 	"cloudeng.io/file"
 	"cloudeng.io/file/filewalk"
 	"cloudeng.io/file/filewalk/asyncstat"
-	"cloudeng.io/file/localfs"
 	"cloudeng.io/path/cloudpath"
 	"cloudeng.io/text/linewrap"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"golang.org/x/term"
 )
 
@@ -35,41 +37,190 @@ type WalkerFlags struct {
 type locateFlags struct {
 	WalkerFlags
 	Exclusions      flags.Repeating `subcmd:"exclude,,exclude directories matching the specified regexp patterns"`
+	ExcludeNames    flags.Repeating `subcmd:"exclude-name,,'exclude directories whose name matches the specified glob patterns, eg. vendor'"`
+	ExcludeGlobs    flags.Repeating `subcmd:"exclude-glob,,'exclude directories whose path, relative to the starting location, matches the specified glob patterns, where ** matches any number of directories, eg. **/build'"`
+	ExcludeFrom     string          `subcmd:"exclude-from,,'read exclusions, one per line, from the specified file. Each line may be prefixed with re:, glob: or name: to specify the type of pattern, the default is glob'"`
+	Includes        flags.Repeating `subcmd:"include,,'only report matches within directories whose path, relative to the starting location, matches the specified glob patterns, where ** matches any number of directories, eg. src or **/testdata. Only the directories that match, or may contain a directory that matches, are searched'"`
+	QuietErrors     string          `subcmd:"quiet-errors,,'comma separated list of the classes of error that are not displayed as they are encountered, any of permission, notexist, loop, io, throttled, canceled or other. They are still included in the summary of errors displayed once the search is complete but do not affect the exit status'"`
+	Stats           bool            `subcmd:"stats,false,'print statistics, such as the number of directories scanned, stat calls issued, matches, errors and directories excluded, to stderr once the search is complete or is interrupted'"`
+	StatsFormat     string          `subcmd:"stats-format,text,'format of the statistics printed by --stats, text or json'"`
+	Progress        bool            `subcmd:"progress,false,'periodically display the progress of the search on stderr, as a status line on a terminal or as log lines otherwise, or ndjson objects for the json formats'"`
+	ProgressPeriod  time.Duration   `subcmd:"progress-interval,0s,'interval between progress updates, the default is 500ms on a terminal and 10s otherwise'"`
 	SameDevice      bool            `subcmd:"same-device,true,only search directories on the same device as the starting directory"`
 	FollowSoftLinks bool            `subcmd:"follow-softlinks,false,follow softlinks"`
 	Long            bool            `subcmd:"l,false,show detailed information about each match"`
 	Sorted          bool            `subcmd:"sorted,false,'output in sorted, depth-first order, like the find command'"`
+	SortedLookahead int             `subcmd:"sorted-lookahead,100,'number of directories that may be scanned concurrently, ahead of the directory being searched, when --sorted is specified. The output is the same regardless, 0 disables scanning ahead'"`
 	Depth           int             `subcmd:"depth,-1,limit the depth of the search"`
+	MinDepth        int             `subcmd:"mindepth,0,'do not report matches at depths less than the specified depth, the starting location is at depth 0 and its entries at depth 1, hence --mindepth=1 suppresses the starting location'"`
+	Format          string          `subcmd:"format,text,'output format, one of text, json (an array of objects) or ndjson (one object per line), errors are written to stderr as ndjson for both json formats'"`
+	Printf          string          `subcmd:"printf,,'format each match using a find(1) style format, see expression-syntax for details'"`
+	Print0          bool            `subcmd:"print0,false,'terminate each match, and each error and message, with a NUL rather than a newline, for use with xargs -0'"`
+	Exec            string          `subcmd:"exec,,'run the specified command for each match rather than displaying it, {} is replaced by the path of the match. Arguments may be quoted using single or double quotes, as per the shell. If the command ends with {} + then the matches are passed to the command in batches, as per find -exec {} +'"`
+	ExecConcurrency int             `subcmd:"exec-concurrency,0,'number of commands that may be run concurrently by --exec, the default is the number of CPUs'"`
+	Delete          bool            `subcmd:"delete,false,'delete matching files and directories, this is a dry run that lists what would be deleted unless --yes is also specified. Directories are deleted after their contents have been searched and only if they are empty, unless --recursive is specified'"`
+	Yes             bool            `subcmd:"yes,false,'actually delete files and directories when --delete is specified'"`
+	Recursive       bool            `subcmd:"recursive,false,'delete matching directories and all of their contents when --delete is specified'"`
+	Limit           int             `subcmd:"limit,0,'stop after the specified number of matches have been reported'"`
+	First           bool            `subcmd:"first,false,'stop after the first match, as per --limit=1'"`
+	Top             int             `subcmd:"top,0,'only display the specified number of matches that rank highest according to --by, they are displayed, best first, once the search is complete'"`
+	By              string          `subcmd:"by,size,'the value used to rank matches for --top, one of size, mtime, atime or entries, which select the largest, newest, most recently accessed or largest directories respectively. A leading - selects the smallest, oldest etc, eg. --by=-mtime'"`
+	SortBy          string          `subcmd:"sort-by,,'sort all of the matches, once the search is complete, using a comma separated list of keys, one of path, name, depth, size, mtime or atime, each of which may be preceded by - to reverse the default, ascending, order, eg. --sort-by=size,-mtime,path'"`
+	SortBuffer      int             `subcmd:"sort-buffer,100000,'maximum number of matches that --sort-by sorts in memory, larger result sets are sorted using temporary files'"`
+	FromFile        string          `subcmd:"from-file,,'read additional starting locations, one per line, from the specified file, or stdin if the file is -. All of the arguments are then treated as the expression unless -- is used to separate starting locations from the expression. Starting locations on the same file system are searched concurrently, those on different file systems, eg. local and s3, are searched in turn'"`
+	Stdin           bool            `subcmd:"stdin,false,'read additional starting locations from stdin, as per --from-file=-'"`
+	NullInput       bool            `subcmd:"null-input,false,'starting locations read via --from-file or --stdin are separated by NUL rather than newline characters, as produced by --print0'"`
+	ContainsReaders int             `subcmd:"contains-readers,100,number of files that may be read concurrently by the contains and contains-fixed operands"`
+	ContainsMax     string          `subcmd:"contains-max-bytes,10MiB,'maximum number of bytes to read from each file for the contains and contains-fixed operands, 0 for no limit'"`
+	ContainsBinary  bool            `subcmd:"contains-binary,false,'search binary files, ie. those with a NUL byte in their first 8KiB, with the contains and contains-fixed operands'"`
+	ExactDirSize    bool            `subcmd:"exact-dir-size,false,'determine the number of entries in each directory before evaluating any of them, so that dir-larger, dir-smaller and entries are evaluated exactly rather than incrementally'"`
+	ExactDirBuffer  int             `subcmd:"exact-dir-size-buffer,100000,'maximum number of entries per directory that --exact-dir-size may buffer when --sorted is specified, larger directories are scanned twice'"`
+	Index           string          `subcmd:"index,,'search the specified index, as created by index build, rather than the file system it was built from. The starting locations default to those that the index was built from'"`
+	Gitignore       bool            `subcmd:"gitignore,false,'ignore files and directories as specified by any .gitignore, .ignore and .git/info/exclude files encountered, .git directories are always ignored'"`
 }
 
-func (w *WalkerFlags) Options(lf *locateFlags) (fwo []filewalk.Option, aso []asyncstat.Option, wo []walkerOption, err error) {
+// jsonOutput returns true if either of the json output formats
+// has been requested.
+func (lf *locateFlags) jsonOutput() bool {
+	return lf.Format == jsonFormat || lf.Format == ndjsonFormat
+}
+
+func (lf *locateFlags) validate() error {
+	if err := validateFormat(lf.Format); err != nil {
+		return err
+	}
+	if len(lf.Printf) > 0 && (lf.Long || lf.jsonOutput()) {
+		return fmt.Errorf("--printf cannot be used with --l or --format=%v", lf.Format)
+	}
+	if lf.Print0 && (len(lf.Printf) > 0 || lf.jsonOutput()) {
+		return fmt.Errorf("--print0 cannot be used with --printf or --format=%v", lf.Format)
+	}
+	if len(lf.Exec) > 0 && (lf.Long || lf.Print0 || len(lf.Printf) > 0 || lf.jsonOutput()) {
+		return fmt.Errorf("--exec cannot be used with --l, --print0, --printf or --format=%v", lf.Format)
+	}
+	if lf.Delete && (lf.Long || len(lf.Exec) > 0 || len(lf.Printf) > 0 || lf.jsonOutput()) {
+		return fmt.Errorf("--delete cannot be used with --l, --exec, --printf or --format=%v", lf.Format)
+	}
+	if !lf.Delete && (lf.Yes || lf.Recursive) {
+		return fmt.Errorf("--yes and --recursive can only be used with --delete")
+	}
+	if lf.Limit < 0 || (lf.First && lf.Limit > 1) {
+		return fmt.Errorf("--limit must be positive and cannot be used with --first")
+	}
+	if lf.Delete && (lf.Limit > 0 || lf.First) {
+		return fmt.Errorf("--delete cannot be used with --limit or --first")
+	}
+	if len(lf.Index) > 0 && (lf.Delete || lf.Gitignore || lf.FollowSoftLinks) {
+		return fmt.Errorf("--index cannot be used with --delete, --gitignore or --follow-softlinks")
+	}
+	if pf, _ := newPrintfFormat(lf.Printf); len(lf.Index) > 0 && pf.needsUnindexed() {
+		return fmt.Errorf("--printf %%b, %%k and %%n cannot be used with --index since it does not record the number of blocks or hard links")
+	}
+	if _, err := parseErrorClasses(lf.QuietErrors); err != nil {
+		return err
+	}
+	if lf.Top < 0 || (lf.Top > 0 && (lf.Limit > 0 || lf.First || lf.Delete)) {
+		return fmt.Errorf("--top must be positive and cannot be used with --limit, --first or --delete")
+	}
+	if _, _, err := parseTopKey(lf.By); lf.Top > 0 && err != nil {
+		return err
+	}
+	if len(lf.SortBy) > 0 && (lf.Top > 0 || lf.Limit > 0 || lf.First || lf.Delete) {
+		return fmt.Errorf("--sort-by cannot be used with --top, --limit, --first or --delete")
+	}
+	if _, err := parseSortKeys(lf.SortBy); len(lf.SortBy) > 0 && err != nil {
+		return err
+	}
+	if len(lf.Index) > 0 && lf.ranksByAccessTime() {
+		return fmt.Errorf("--by=atime and --sort-by=atime cannot be used with --index since it does not record access times")
+	}
+	if lf.Stats && lf.StatsFormat != textFormat && lf.StatsFormat != "json" {
+		return fmt.Errorf("unsupported --stats-format: %q, use text or json", lf.StatsFormat)
+	}
+	if lf.SortedLookahead < 0 {
+		return fmt.Errorf("--sorted-lookahead must be positive")
+	}
+	if lf.Stdin && len(lf.FromFile) > 0 {
+		return fmt.Errorf("--stdin cannot be used with --from-file")
+	}
+	return nil
+}
+
+// ranksByAccessTime returns true if --top or --sort-by rank matches by
+// their access time.
+func (lf *locateFlags) ranksByAccessTime() bool {
+	if lf.Top > 0 && strings.TrimPrefix(lf.By, "-") == "atime" {
+		return true
+	}
+	keys, _ := parseSortKeys(lf.SortBy)
+	return len(lf.SortBy) > 0 && slices.ContainsFunc(keys, func(k sortKey) bool { return k.name == "atime" })
+}
+
+// exclusions returns the exclusions specified by the flags.
+func (lf *locateFlags) exclusions() (*exclusions, error) {
+	ex, err := newExclusions(lf.Exclusions.Values, lf.ExcludeGlobs.Values, lf.ExcludeNames.Values, lf.Includes.Values)
+	if err != nil {
+		return nil, err
+	}
+	if len(lf.ExcludeFrom) > 0 {
+		f, err := os.Open(lf.ExcludeFrom)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := ex.readFrom(f); err != nil {
+			return nil, fmt.Errorf("%v: %v", lf.ExcludeFrom, err)
+		}
+	}
+	return ex, nil
+}
+
+// needsStat returns true if the requested output requires
+// information obtained via stat/lstat.
+func (lf *locateFlags) needsStat() bool {
+	if lf.Long || lf.jsonOutput() || lf.Top > 0 || len(lf.SortBy) > 0 {
+		return true
+	}
+	pf, _ := newPrintfFormat(lf.Printf)
+	return pf.needsStat()
+}
+
+// walkOptions returns the filewalk and asyncstat options specified
+// by the walker flags.
+func (w *WalkerFlags) walkOptions(followSoftLinks bool) (fwo []filewalk.Option, aso []asyncstat.Option) {
 	if w.ConcurrentScans > 0 {
 		fwo = append(fwo, filewalk.WithConcurrentScans(w.ConcurrentScans))
 	}
 	if w.ScanSize > 0 {
 		fwo = append(fwo, filewalk.WithScanSize(w.ScanSize))
 	}
-	fwo = append(fwo, filewalk.WithDepth(lf.Depth))
 	if w.ConcurrentStats > 0 {
 		aso = append(aso, asyncstat.WithAsyncStats(w.ConcurrentStats))
 	}
 	if w.ConcurrentStatsThreshold > 0 {
 		aso = append(aso, asyncstat.WithAsyncThreshold(w.ConcurrentStatsThreshold))
 	}
-	if lf.FollowSoftLinks {
+	if followSoftLinks {
 		aso = append(aso, asyncstat.WithStat())
 	} else {
 		aso = append(aso, asyncstat.WithLStat())
 	}
-	ex, err := newExclusions(lf.Exclusions.Values)
-	if err != nil {
-		return
-	}
+	return
+}
+
+// Options returns the filewalk, asyncstat and walker options specified
+// by the walker and locate flags, the exclusions specified by the flags
+// are not included since they are created once, by locate, and shared
+// by all of the walks.
+func (w *WalkerFlags) Options(lf *locateFlags) (fwo []filewalk.Option, aso []asyncstat.Option, wo []walkerOption) {
+	fwo, aso = w.walkOptions(lf.FollowSoftLinks)
+	fwo = append(fwo, filewalk.WithDepth(lf.Depth))
 	wo = append(wo,
 		withFollowSoftLinks(lf.FollowSoftLinks),
 		withScanSize(w.ScanSize),
 		withDepth(lf.Depth),
-		withExclusions(ex))
+		withMinDepth(lf.MinDepth),
+		withLookahead(lf.SortedLookahead))
 	return
 }
 
@@ -111,97 +262,563 @@ levels must be specified, i.e. 'name=/*/*/baz' is required to match
 /foo/bar/baz. The re (regexp) operator can be used to match any level,
  for example 're=bar' will match '/foo/bar/baz' as will 're=bar/baz.
 
+The starting location is evaluated against the expression in the same
+way as all of the files and directories below it and is reported if it
+matches. It is at depth 0, its entries are at depth 1 and so on, and
+--mindepth can be used to suppress matches at lower depths, eg.
+--mindepth=1 suppresses the starting location.
+
 The dir-larger operand matches directories that contain more than the
 specified number incrementally and hence entries that are encountered
-before the limit is reached may not be displayed.
+before the limit is reached may not be displayed. The --exact-dir-size
+flag can be used to count all of the entries in each directory before
+any of them are evaluated, at the cost of an additional scan of each
+directory, so that the results do not depend on --dir-scan-size.
+
+The size, entries, depth and nlink operands compare numeric values using
+any of <, <=, >, >= or =, which may be written in place of the =, eg.
+size>10MiB or entries<=5. Unlike file-larger and dir-larger, the
+comparison, and hence whether the bound is inclusive, is explicit.
+
+The mtime, atime and ctime operands, and those that refer to the time
+a file was modified, accessed or changed, accept either an age, such as
+36h, 1.5d or 1w2d where the units are s, m, h, d (days) and w (weeks), or
+a time in time.RFC3339, time.DateTime or time.DateOnly formats. The
+comparison operator may be written in place of the =, eg. mtime<7d or
+mtime>=2024-01-01. For an age, = matches at the granularity of its last
+unit, eg. mtime=2d matches files that are between 2 and 3 days old, and for
+a time, = matches at the granularity of that time, eg. mtime=2024-01-01
+matches any time on that day. Access and change times are not available
+for all file systems, in which case they never match.
+
+The contains and contains-fixed operands match the contents of regular
+files against a regular expression or a fixed string respectively. A file
+is only read when the result of the expression depends on its contents,
+eg. for 'name=*.go && contains=TODO' only files ending in .go are read.
+Files are read concurrently, subject to --contains-readers, whilst
+directories continue to be scanned, and are matched as they are read,
+stopping once all of the operands have matched. Only the first
+--contains-max-bytes of each file are examined. Binary files, those with
+a NUL byte in their first 8KiB, are skipped unless --contains-binary is
+specified.
+
+The prune operand stops descent into directories for which it determines
+the result of the expression, ie. for which the expression is true only
+because prune is, eg. '((name=node_modules || name=.git) && prune) ||
+name=*.ts' finds all .ts files outside of node_modules and .git
+directories. Pruned directories are not themselves reported. Note that
+&& and || have the same precedence and are evaluated left to right, eg.
+a || b && c is evaluated as (a || b) && c, and hence parentheses should
+be used to group them as intended when both are used in the same
+expression.
+
+Directories may also be excluded from the search using --exclude, a
+regular expression matched against the full path name, --exclude-name,
+a glob matched against the name of the directory, eg.
+--exclude-name=vendor, or --exclude-glob, a glob matched against the path
+of the directory relative to the starting location where ** matches any
+number of directories, eg. --exclude-glob='**/build'. --exclude-from
+reads exclusions from a file, one per line, optionally prefixed with re:,
+glob: or name:, the default being glob. Conversely, --include limits the
+search, and the matches reported, to the subtrees that match the
+specified globs, eg. --include=src or --include='**/testdata', where **
+matches any number of directories. Directories that may contain such a
+subtree are searched, but only matches within it are reported. --stats
+displays the number of directories skipped by each exclusion.
+
+--top displays only the specified number of matches that rank highest
+according to --by, which may be one of size, mtime, atime or entries to
+select the largest, newest, most recently accessed or largest directories
+respectively, or any of them preceded by - to select the smallest, oldest
+etc. The number of entries in a directory is that found when it is
+searched, directories that are not searched, eg. because of --depth or an
+exclusion, rank as having none. The matches are retained in a bounded
+heap and displayed, best first, once the search is complete, eg. --top=20
+--by=size 'type=f && mtime<30d' displays the 20 largest files modified in
+the last 30 days.
+
+Errors encountered during the search, including the failures of --exec
+commands and of deletions, are grouped into classes: permission, notexist
+(including files deleted whilst being searched), loop (symbolic link
+loops), io, throttled (S3 throttling), canceled and other. S3 access
+denied errors are treated as permission errors. --quiet-errors suppresses
+the display of the specified classes as they are encountered, eg.
+--quiet-errors=permission,notexist. A summary of the errors, grouped by
+class, is displayed once the search is complete for text output. The exit
+status is 0 if any matches were found, 1 if there were none and 2 if any
+errors, other than those suppressed by --quiet-errors, were encountered.
+
+--stats prints statistics to stderr once the search is complete, or is
+interrupted, including the number of directories scanned, the entries
+they contain, the peak number of concurrent scans, the number of stat
+calls issued synchronously and asynchronously, the matches and the bytes
+they contain, the errors encountered grouped by class and the directories
+skipped by each exclusion. These can be used to tune
+--concurrent-dir-scans, --dir-scan-size and --async-stats-* for a
+particular file system. --stats-format=json prints them as a JSON object.
+
+--progress periodically displays the number of directories scanned and
+queued, the entries seen and the rate at which they are being seen, the
+matches, the errors and the deepest directory scanned so far. On a
+terminal these are displayed as a single status line on stderr that is
+cleared before any match or error is displayed, otherwise as a log line
+every --progress-interval, or as an ndjson object for the json formats.
+
+--sort-by sorts all of the matches, once the search is complete, using a
+comma separated list of keys, one of path, name, depth, size, mtime or
+atime, each of which may be preceded by - to reverse the default,
+ascending, order, eg. --sort-by=size,-mtime,path. Ties are broken using
+the path. Up to --sort-buffer matches are sorted in memory, larger
+result sets are written to temporary files as sorted runs that are then
+merged.
+
+--index searches an index, as created by the index build command,
+rather than the file system that it was built from. The starting
+locations default to those that the index was built from. The contains
+and contains-fixed operands, --follow-softlinks, --gitignore and
+--delete cannot be used with an index. Nor can the atime, ctime and
+nlink operands, the %b, %k and %n --printf verbs or ranking by atime
+with --by or --sort-by since an index does not record access or change
+times or the number of blocks or hard links.
+
+--sorted displays matches in depth-first order, with the entries of each
+directory in the order returned by the file system, like find(1). The
+directories that are about to be searched are scanned concurrently,
+subject to --sorted-lookahead, ahead of being searched so that the
+output is ordered without having to search one directory at a time.
 `)
 
 	out.WriteString(`
 The expression may span multiple arguments which are concatenated together using spaces. Operand values may be quoted using single quotes or may contain escaped characters using. For example re='a b.pdf' or or re=a\\ b.pdf\n
 `)
 	fmt.Println(linewrap.Block(4, terminal_width, out.String()))
+	fmt.Println(linewrap.Block(4, terminal_width, printfDoc))
 	return nil
 }
 
 type visitor func(parent, name string, entry filewalk.Entry, fi *file.Info, err error)
 
 type visit struct {
-	ctx context.Context
-	fs  filewalk.FS
-	lf  *locateFlags
+	ctx    context.Context
+	fs     filewalk.FS
+	lf     *locateFlags
+	out    *output
+	roots  []string
+	printf printfFormat
+	exec   *execRunner
+	delete *deleter
+	limit  *matchLimit
+	top    *topMatches
+	sorter *matchSorter
+	stats  *walkStats
+	// outcome records the matches and errors that determine the exit
+	// status.
+	outcome *outcome
+	// group identifies the group of starting locations, and hence file
+	// system, being searched.
+	group int
+}
+
+// rootFor returns the starting location under which path was found.
+// The starting locations are cleaned, see cleanRoots, as are the paths
+// found below them.
+func (v visit) rootFor(path string) string {
+	if len(v.roots) == 1 {
+		return v.roots[0]
+	}
+	var root string
+	for _, r := range v.roots {
+		if hasPathPrefix(path, r) && len(r) > len(root) {
+			root = r
+		}
+	}
+	return root
 }
 
 func (v visit) visit(parent, name string, entry filewalk.Entry, fi *file.Info, err error) {
+	path := v.fs.Join(parent, name)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v: %v\n", v.fs.Join(parent, name), err)
+		if (v.limit.reached() || v.ctx.Err() != nil) && errors.Is(err, context.Canceled) {
+			return
+		}
+		v.error(path, err)
 		return
 	}
-	if fi == nil || !v.lf.Long {
-		fmt.Println(v.fs.Join(parent, name))
+	if !v.limit.take() {
 		return
 	}
-	xattr, err := v.fs.XAttr(v.ctx, v.fs.Join(parent, name), *fi)
+	v.stats.matched(fi)
+	v.outcome.matched()
+	if len(name) == 0 {
+		// The starting location is reported with an empty name, see
+		// walkerOptions.matchRoot.
+		parent, name = "", entry.Name
+	}
+	if v.top != nil {
+		v.top.add(v, path, parent, name, entry, fi)
+		return
+	}
+	if v.sorter != nil {
+		v.sorter.add(v, path, parent, name, entry, fi)
+		return
+	}
+	v.display(path, parent, name, entry, fi)
+}
+
+// options returns the walker options required by --top and --delete.
+func (v visit) options() []walkerOption {
+	var wo []walkerOption
+	if v.top != nil {
+		wo = append(wo, withDirEntries(v.top.dirEntries))
+	}
+	if v.delete != nil {
+		wo = append(wo, withPostDir(v.delete.postDir))
+	}
+	return wo
+}
+
+// finish is called once all of the walks are complete, with any errors
+// that they returned, to display the matches retained by --top or
+// --sort-by, to wait for any commands run by --exec and to complete any
+// deletions.
+func (v visit) finish(err error) error {
+	if v.top != nil {
+		for _, m := range v.top.sorted() {
+			m.v.display(m.path, m.parent, m.name, m.entry, m.fi)
+		}
+	}
+	if v.sorter != nil {
+		err = errors.Join(err, v.sorter.finish(func(m sortedMatch) {
+			m.v.display(m.path, m.parent, m.name, m.entry, m.fi)
+		}))
+	}
+	if v.limit != nil {
+		err = v.limit.walkDone(err)
+	}
+	if v.exec != nil {
+		err = errors.Join(err, v.exec.wait())
+	}
+	if v.delete != nil {
+		err = errors.Join(err, v.delete.finish(v.ctx))
+	}
+	return err
+}
+
+// display displays, runs a command for or deletes, a single match.
+func (v visit) display(path, parent, name string, entry filewalk.Entry, fi *file.Info) {
+	if v.exec != nil {
+		v.exec.add(path)
+		return
+	}
+	if v.delete != nil {
+		isDir := entry.IsDir()
+		if fi != nil {
+			isDir = fi.IsDir()
+		}
+		v.delete.match(v.ctx, path, isDir)
+		return
+	}
+	switch {
+	case v.lf.jsonOutput():
+		v.out.record(v.record(path, parent, name, entry, fi))
+	case v.printf != nil:
+		v.out.raw(v.printf.format(v.printfMatch(path, parent, name, entry, fi)))
+	case fi == nil || !v.lf.Long:
+		v.out.text(path)
+	default:
+		user, group := userAndGroup(v.xattr(path, fi))
+		v.out.text(fmt.Sprintf("%s: %s (%v, %v)", path, fs.FormatFileInfo(fi), user, group))
+	}
+}
+
+// error records an error encountered for path and displays it unless
+// its class has been suppressed by --quiet-errors.
+func (v visit) error(path string, err error) {
+	class := classifyError(v.fs, err)
+	v.stats.error(class)
+	if v.outcome.error(class, path) {
+		v.out.error(path, err)
+	}
+}
+
+// xattr returns the file.XAttr for the supplied file, any errors
+// are displayed and a zero value returned.
+func (v visit) xattr(path string, fi *file.Info) file.XAttr {
+	xattr, err := v.fs.XAttr(v.ctx, path, *fi)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v: %v\n", v.fs.Join(parent, name), err)
+		v.error(path, err)
 	}
-	var user, group = fmt.Sprintf("%v", xattr.UID), fmt.Sprintf("%v", xattr.GID)
-	if id, err := idm.LookupUser(user); err == nil {
-		user = id.Username
+	return xattr
+}
+
+// userAndGroup returns the user and group names for the supplied
+// xattr, falling back to the numeric ids if they cannot be found.
+func userAndGroup(xattr file.XAttr) (string, string) {
+	var user, group = xattr.User, xattr.Group
+	if len(user) == 0 {
+		user = fmt.Sprintf("%v", xattr.UID)
+		if id, err := idm.LookupUser(user); err == nil {
+			user = id.Username
+		}
+	}
+	if len(group) == 0 {
+		group = fmt.Sprintf("%v", xattr.GID)
+		if id, err := idm.LookupGroup(group); err == nil {
+			group = id.Name
+		}
+	}
+	return user, group
+}
+
+func (v visit) record(path, parent, name string, entry filewalk.Entry, fi *file.Info) record {
+	r := record{
+		Path:   path,
+		Parent: parent,
+		Name:   name,
+		Type:   typeLetter(entry.Type),
 	}
-	if id, err := idm.LookupGroup(group); err == nil {
-		group = id.Name
+	if fi == nil {
+		return r
 	}
-	fmt.Printf("%s: %s (%v, %v)\n", v.fs.Join(parent, name), fs.FormatFileInfo(fi), user, group)
+	r.Type = typeLetter(fi.Mode())
+	xattr := v.xattr(path, fi)
+	user, group := userAndGroup(xattr)
+	r.statRecord = newStatRecord(fi, xattr, user, group)
+	return r
+}
+
+func (v visit) printfMatch(path, parent, name string, entry filewalk.Entry, fi *file.Info) printfMatch {
+	m := printfMatch{
+		path:   path,
+		parent: parent,
+		name:   name,
+		root:   v.rootFor(path),
+		typ:    typeLetter(entry.Type),
+		fi:     fi,
+	}
+	if fi == nil {
+		return m
+	}
+	m.typ = typeLetter(fi.Mode())
+	if v.printf.needsXAttr() {
+		m.xattr = v.xattr(path, fi)
+		m.user, m.group = userAndGroup(m.xattr)
+	}
+	return m
 }
 
 func (lc locateCmd) locate(ctx context.Context, values interface{}, args []string) error {
-	loc := args[0]
-	match := cloudpath.DefaultMatchers.Match(loc)
-	if len(match.Matched) == 0 {
-		return fmt.Errorf("unsupported path: %v", loc)
-	}
-	var wkfs filewalk.FS
-	switch match.Scheme {
-	case "s3":
-		cfg, err := awsconfig.Load(ctx)
+	lf := values.(*locateFlags)
+	if err := lf.validate(); err != nil {
+		return err
+	}
+	exclude, err := lf.exclusions()
+	if err != nil {
+		return err
+	}
+	fromFile := lf.FromFile
+	if lf.Stdin {
+		fromFile = "-"
+	}
+	roots, expr := splitLocateArgs(args, len(fromFile) > 0)
+	if len(fromFile) > 0 {
+		listed, err := readRootsFrom(fromFile, lf.NullInput)
 		if err != nil {
-			return fmt.Errorf("failed to load AWS config: %v", err)
+			return err
 		}
-		wkfs = s3fs.New(cfg)
-	case "unix":
-		wkfs = localfs.New()
-	default:
-		return fmt.Errorf("unsupported file system scheme: %v", match.Scheme)
+		roots = append(roots, listed...)
 	}
-	lf := values.(*locateFlags)
-	visit := visit{fs: wkfs, ctx: ctx, lf: lf}
-	return lc.locateFS(ctx, wkfs, lf, visit.visit, args)
+	if len(roots) == 0 && len(lf.Index) == 0 {
+		return fmt.Errorf("no starting locations specified")
+	}
+	var filesystems fileSystems
+	var groups []*rootGroup
+	if len(lf.Index) > 0 {
+		if e, err := createExpr(expr); err == nil && e.NeedsContent() {
+			return fmt.Errorf("the contains and contains-fixed operands cannot be used with --index")
+		} else if err == nil && e.NeedsUnindexed() {
+			return fmt.Errorf("%v cannot be used with --index since it does not record access or change times or the number of hard links", unindexedOperands)
+		}
+		groups, err = indexGroups(lf.Index, roots)
+	} else {
+		groups, err = filesystems.group(ctx, roots)
+	}
+	if err != nil {
+		return err
+	}
+	pf, err := newPrintfFormat(lf.Printf)
+	if err != nil {
+		return err
+	}
+	var stats *walkStats
+	if lf.Stats {
+		// An interrupt stops the search so that the statistics gathered
+		// so far can be printed, a second one exits immediately.
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+	}
+	if lf.Stats || lf.Progress {
+		stats = newWalkStats(exclude)
+	}
+	quiet, err := parseErrorClasses(lf.QuietErrors)
+	if err != nil {
+		return err
+	}
+	out := newOutput(os.Stdout, os.Stderr, lf.Format, lf.Print0)
+	visit := visit{ctx: ctx, lf: lf, out: out, printf: pf, stats: stats, outcome: newOutcome(quiet)}
+	// Failures of --exec commands and of deletions are classified and
+	// recorded as per the errors encountered when searching the file
+	// system that the path belongs to.
+	schemeFS := map[string]filewalk.FS{}
+	for _, g := range groups {
+		schemeFS[g.scheme] = g.fs
+	}
+	actionError := func(path string, err error) {
+		v := visit
+		v.fs = schemeFS[cloudpath.DefaultMatchers.Match(path).Scheme]
+		v.error(path, err)
+	}
+	if len(lf.Exec) > 0 {
+		visit.exec, err = newExecRunner(ctx, lf.Exec, lf.ExecConcurrency, out, actionError)
+		if err != nil {
+			return err
+		}
+	}
+	// The exclusions are shared by all of the file systems searched so
+	// that the directories they skip are counted across all of them.
+	wo := []walkerOption{withExclusions(exclude)}
+	if lf.Delete {
+		visit.delete = newDeleter(out, actionError, !lf.Yes, lf.Recursive)
+		removers := schemeRemovers{}
+		for _, g := range groups {
+			removers[g.scheme] = localRemover{}
+			if g.scheme == "s3" {
+				removers[g.scheme] = newS3Remover(s3.NewFromConfig(filesystems.cfg), visit.delete.failure)
+			}
+		}
+		visit.delete.rm = removers
+	}
+	walkCtx := ctx
+	if lf.First {
+		lf.Limit = 1
+	}
+	if lf.Limit > 0 {
+		walkCtx, visit.limit = newMatchLimit(ctx, lf.Limit)
+	}
+	if lf.Top > 0 {
+		if visit.top, err = newTopMatches(lf.Top, lf.By); err != nil {
+			return err
+		}
+	}
+	if len(lf.SortBy) > 0 {
+		if visit.sorter, err = newMatchSorter(lf.SortBy, lf.SortBuffer); err != nil {
+			return err
+		}
+	}
+	wo = append(wo, visit.options()...)
+	out.begin()
+	defer out.end()
+	var progress *progress
+	if lf.Progress {
+		tty := term.IsTerminal(int(os.Stderr.Fd()))
+		progress = startProgress(stats, out, tty, terminal_width, lf.ProgressPeriod)
+	}
+	// The groups are searched in turn so that they share the
+	// concurrency limits specified by the walker flags.
+	for i, g := range groups {
+		if walkCtx.Err() != nil {
+			break
+		}
+		visit := visit
+		visit.fs, visit.roots, visit.group = stats.wrap(g.fs), cleanRoots(g.fs, g.roots), i
+		gargs := append(append(g.roots[:len(g.roots):len(g.roots)], "--"), expr...)
+		err = errors.Join(err, lc.locateFS(walkCtx, visit.fs, lf, visit.visit, gargs, wo...))
+	}
+	if progress != nil {
+		progress.stop()
+	}
+	err = visit.finish(err)
+	if !lf.jsonOutput() {
+		visit.outcome.summarize(out)
+	}
+	// An interrupted search is reported as such, rather than as having
+	// found no matches, when statistics are requested.
+	interrupted := lf.Stats && ctx.Err() != nil
+	if err == nil && !interrupted {
+		err = visit.outcome.err()
+	}
+	if lf.Stats {
+		if interrupted {
+			err = errors.Join(err, errInterrupted)
+		}
+		err = errors.Join(err, stats.report(os.Stderr, lf.StatsFormat))
+	}
+	return err
 }
 
 func (lc locateCmd) locateFS(ctx context.Context,
 	wkfs filewalk.FS,
 	lf *locateFlags,
 	visit visitor,
-	args []string) error {
-	wko, aso, wo, err := lf.WalkerFlags.Options(lf)
-	if err != nil {
-		return err
+	args []string,
+	opts ...walkerOption) error {
+	wko, aso, wo := lf.WalkerFlags.Options(lf)
+	wo = append(wo, opts...)
+	roots, exprArgs := splitLocateArgs(args, false)
+	if len(roots) == 0 {
+		return fmt.Errorf("no starting locations specified")
 	}
+	roots = uniqueRoots(wkfs, roots)
 	if lf.SameDevice {
-		sd, err := newSameDevice(ctx, wkfs, args[0])
+		sd, err := newSameDevice(ctx, wkfs, roots...)
 		if err != nil {
 			return err
 		}
 		wo = append(wo, withSameDevice(sd))
 	}
-	stats := asyncstat.New(wkfs, aso...)
-	expr, err := createExpr(args[1:])
+	if lf.Gitignore {
+		wo = append(wo, withGitignore(newGitignore(wkfs)))
+	}
+	walkStats := statsFor(wkfs)
+	stats := newStatProcessor(asyncstat.New(wkfs, aso...), walkStats)
+	expr, err := createExpr(exprArgs)
 	if err != nil {
 		return err
 	}
-	wo = append(wo, withStats(expr.NeedsStat() || lf.Long))
+	wo = append(wo, withStats(expr.NeedsStat() || lf.needsStat()))
+	if lf.ExactDirSize && expr.NeedsNumEntries() {
+		wo = append(wo, withExactNumEntries(lf.ExactDirBuffer))
+	}
+	var ce *contentEvaluator
+	if expr.NeedsContent() {
+		var maxBytes int64
+		if len(lf.ContainsMax) > 0 {
+			if maxBytes, err = parseSize(lf.ContainsMax); err != nil {
+				return fmt.Errorf("--contains-max-bytes: %v", err)
+			}
+		}
+		ce = newContentEvaluator(wkfs, lf.ContainsReaders, maxBytes, lf.ContainsBinary)
+		wo = append(wo, withContentEvaluator(ce))
+	}
 	if !lf.Sorted {
-		return newWalker(expr, wkfs, stats, wko, wo, visit).Walk(ctx, args[0])
+		w := newWalker(expr, wkfs, stats, wko, wo, visit)
+		err := w.Walk(ctx, roots...)
+		// Wait for any files that are still being read.
+		ce.wait()
+		if walkStats != nil {
+			walkStats.synchronousScans.Add(w.Stats().SynchronousScans)
+		}
+		return err
+	}
+	df := newDepthFirstWalker(expr, wkfs, stats, wo, visit)
+	var errs []error
+	for _, root := range roots {
+		errs = append(errs, df.start(ctx, root))
 	}
-	return newDepthFirstWalker(expr, wkfs, stats, wo, visit).start(ctx, args[0])
+	return errors.Join(errs...)
 }
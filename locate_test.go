@@ -212,6 +212,7 @@ func zips(a ...string) []string {
 }
 
 var rawPaths = []string{
+	"/",
 	"/f2",
 	"/inaccessible-dir",
 	"/a0",
@@ -248,6 +249,11 @@ var rawPaths = []string{
 func genFound(entries []string) []found {
 	f := []found{}
 	for _, dir := range entries {
+		if dir == "/" {
+			// The starting location itself.
+			f = append(f, found{})
+			continue
+		}
 		d := filepath.Dir(dir)
 		if d == "/" || d == "\\" {
 			d = ""
@@ -302,6 +308,13 @@ func init() {
 	sortFound(allDirs)
 }
 
+// withRoot returns f with the addition of the starting location.
+func withRoot(f []found) []found {
+	r := append([]found{{}}, f...)
+	sortFound(r)
+	return r
+}
+
 func TestNamesAndPaths(t *testing.T) {
 	ctx := context.Background()
 	for _, sorted := range []bool{false, true} {
@@ -330,7 +343,7 @@ func TestNamesAndPaths(t *testing.T) {
 			cmpFound(t, foundErrors, expectedErrors)
 
 			found, foundErrors = locate(ctx, t, lf, localTestTree, "type=d")
-			cmpFound(t, found, allDirs)
+			cmpFound(t, found, withRoot(allDirs))
 			cmpFound(t, foundErrors, expectedErrors)
 		}
 	}
@@ -395,3 +408,52 @@ func TestNumEntries(t *testing.T) {
 		}
 	}
 }
+
+func TestRootAndMinDepth(t *testing.T) {
+	ctx := context.Background()
+	locateBoth := func(lf locateFlags, args ...string) []found {
+		var results [2][]found
+		for i, sorted := range []bool{false, true} {
+			lf := lf
+			lf.Sorted = sorted
+			results[i], _ = locate(ctx, t, &lf, args...)
+		}
+		cmpFound(t, results[0], results[1])
+		return results[0]
+	}
+	for _, expr := range []string{"", "type=d", "type=f", "re=a0", "newer=2010-12-13", "depth<=1", "dir-larger=1"} {
+		for _, minDepth := range []int{0, 1, 2, 3} {
+			for _, long := range []bool{false, true} {
+				lf := locateFlags{Long: long, Depth: -1, MinDepth: minDepth}
+				lf.ScanSize = 1
+				locateBoth(lf, localTestTree, expr)
+			}
+		}
+	}
+
+	lf := locateFlags{Depth: -1}
+	lf.ScanSize = 100
+	root := []found{{}}
+	cmpFound(t, locateBoth(lf, localTestTree, "type=d && depth=0"), root)
+	cmpFound(t, locateBoth(lf, localTestTree, "type=f && depth=0"), nil)
+
+	// The starting location may be a file.
+	file := []found{{"/f0", "", nil}}
+	cmpFound(t, locateBoth(lf, filepath.Join(localTestTree, "f0"), ""), file)
+	cmpFound(t, locateBoth(lf, filepath.Join(localTestTree, "f0"), "type=f"), file)
+	cmpFound(t, locateBoth(lf, filepath.Join(localTestTree, "f0"), "type=d"), nil)
+
+	lf.MinDepth = 1
+	cmpFound(t, locateBoth(lf, localTestTree, "type=d && depth=0"), nil)
+	cmpFound(t, locateBoth(lf, localTestTree, ""), all()[1:])
+	cmpFound(t, locateBoth(lf, filepath.Join(localTestTree, "f0"), ""), nil)
+
+	lf.MinDepth = 3
+	cmpFound(t, locateBoth(lf, localTestTree, ""), genFound([]string{
+		"/a0/a0.0/f0", "/a0/a0.0/f1", "/a0/a0.0/f2",
+		"/a0/a0.1/f0", "/a0/a0.1/f1", "/a0/a0.1/f2",
+		"/b0/b0.0/f0", "/b0/b0.0/f1", "/b0/b0.0/f2",
+		"/b0/b0.1/b1.0",
+		"/b0/b0.1/b1.0/f0", "/b0/b0.1/b1.0/f1", "/b0/b0.1/b1.0/f2",
+	}))
+}
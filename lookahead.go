@@ -0,0 +1,130 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	"cloudeng.io/file/filewalk"
+)
+
+// lookaheadBatches is the number of scanned batches of directory entries
+// that are buffered for each directory that is scanned ahead.
+const lookaheadBatches = 4
+
+// lookahead scans directories ahead of the depth-first walker reaching
+// them so that directory scans, which typically dominate the time
+// taken, proceed concurrently whilst the walker itself, and hence its
+// output, remains strictly ordered. At most n directories are scanned
+// ahead at any one time and each buffers at most lookaheadBatches
+// batches of entries. Directories that are scanned ahead must be
+// claimed using take, even if they are not ultimately walked, so that
+// their scans can be cancelled.
+type lookahead struct {
+	fs       filewalk.FS
+	scanSize int
+	slots    chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]*prefetched
+}
+
+func newLookahead(fs filewalk.FS, scanSize, n int) *lookahead {
+	return &lookahead{
+		fs:       fs,
+		scanSize: scanSize,
+		slots:    make(chan struct{}, n),
+		pending:  map[string]*prefetched{},
+	}
+}
+
+// start starts scanning the directories in dirs, beginning with
+// dirs[next], until either all of them have been started or n scans are
+// already in progress. It returns the index of the first directory
+// that was not started.
+func (la *lookahead) start(ctx context.Context, dirs []string, next int) int {
+	for ; next < len(dirs); next++ {
+		select {
+		case la.slots <- struct{}{}:
+		default:
+			return next
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		p := &prefetched{cancel: cancel, ch: make(chan []filewalk.Entry, lookaheadBatches)}
+		la.mu.Lock()
+		la.pending[dirs[next]] = p
+		la.mu.Unlock()
+		go p.run(ctx, la.fs.LevelScanner(dirs[next]), la.scanSize, func() { <-la.slots })
+	}
+	return next
+}
+
+// take returns the scanner for a directory that is being scanned ahead,
+// or nil if it is not. The caller must call its cancel method once it
+// is done with it.
+func (la *lookahead) take(dir string) *prefetched {
+	if la == nil {
+		return nil
+	}
+	la.mu.Lock()
+	defer la.mu.Unlock()
+	p := la.pending[dir]
+	delete(la.pending, dir)
+	return p
+}
+
+// prefetched implements filewalk.LevelScanner for a directory that is
+// being scanned ahead, returning the same batches of entries, in the
+// same order, as the underlying scanner.
+type prefetched struct {
+	cancel   context.CancelFunc
+	ch       chan []filewalk.Entry
+	contents []filewalk.Entry
+	err      error
+	// scanErr is set before ch is closed.
+	scanErr error
+}
+
+func (p *prefetched) run(ctx context.Context, sc filewalk.LevelScanner, scanSize int, release func()) {
+	defer release()
+	defer close(p.ch)
+	for sc.Scan(ctx, scanSize) {
+		select {
+		case p.ch <- sc.Contents():
+		case <-ctx.Done():
+			p.scanErr = ctx.Err()
+			return
+		}
+	}
+	p.scanErr = sc.Err()
+}
+
+// Scan implements filewalk.LevelScanner. The scan size is determined
+// by the lookahead that created the scanner.
+func (p *prefetched) Scan(ctx context.Context, _ int) bool {
+	select {
+	case contents, ok := <-p.ch:
+		if !ok {
+			p.err = p.scanErr
+			return false
+		}
+		p.contents = contents
+		return true
+	case <-ctx.Done():
+		p.err = ctx.Err()
+		return false
+	}
+}
+
+// Contents implements filewalk.LevelScanner.
+func (p *prefetched) Contents() []filewalk.Entry {
+	return p.contents
+}
+
+// Err implements filewalk.LevelScanner.
+func (p *prefetched) Err() error {
+	return p.err
+}
@@ -6,6 +6,9 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
 
 	"cloudeng.io/cmdutil/subcmd"
 )
@@ -17,8 +20,28 @@ commands:
   - name: locate
     summary: locate files using boolean expressions
     arguments:
-      - <directory>
-      - <expression>...
+      - "<directory> <expression>... or <directory>... -- <expression>..."
+  - name: usage
+    summary: display the disk usage of directories, including all of the files and directories below them
+    arguments:
+      - "<directory>..."
+  - name: index
+    summary: build, update and query an index of the files and directories below a set of starting locations
+    commands:
+      - name: build
+        summary: build an index of all of the files and directories below the specified starting locations
+        arguments:
+          - "<directory>..."
+      - name: update
+        summary: update an index by rescanning only the directories that have changed since it was built, local files edited in place within unchanged directories retain the size, modification time, permissions and owner previously recorded for them
+      - name: query
+        summary: locate files in an index using boolean expressions, as per the locate command
+        arguments:
+          - "<expression>..."
+  - name: diff
+    summary: report the files and directories that have been added, removed, renamed, resized, modified or had their permissions or owner changed between two snapshots, ie. indexes, or between a snapshot and the current tree
+    arguments:
+      - "[<old-snapshot> [<new-snapshot>]] -- <expression>..."
   - name: expression-syntax
     summary: show help on the expression syntax and matching operations
  `
@@ -27,10 +50,25 @@ func cli() *subcmd.CommandSetYAML {
 	cmdSet := subcmd.MustFromYAMLTemplate(commands)
 	locate := locateCmd{}
 	cmdSet.Set("locate").MustRunner(locate.locate, &locateFlags{})
+	cmdSet.Set("usage").MustRunner(usageCmd{}.usage, &usageFlags{})
+	cmdSet.Set("index", "build").MustRunner(indexCmd{}.build, &indexBuildFlags{})
+	cmdSet.Set("index", "update").MustRunner(indexCmd{}.update, &indexUpdateFlags{})
+	cmdSet.Set("index", "query").MustRunner(indexCmd{}.query, &locateFlags{})
+	cmdSet.Set("diff").MustRunner(diffCmd{}.diff, &diffFlags{})
 	cmdSet.Set("expression-syntax").MustRunner(locate.explain, &struct{}{})
 	return cmdSet
 }
 
+// main exits with a status of 0 if any matches were found, 1 if there
+// were none and 2 if any errors were encountered.
 func main() {
-	cli().MustDispatch(context.Background())
+	err := cli().Dispatch(context.Background())
+	switch {
+	case err == nil:
+	case errors.Is(err, errNoMatches):
+		os.Exit(exitNoMatches)
+	default:
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitErrors)
+	}
 }
@@ -0,0 +1,199 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"cloudeng.io/cmdutil/boolexpr"
+	"cloudeng.io/file/diskusage"
+	"cloudeng.io/file/matcher"
+)
+
+// DepthIfc must be implemented by any values that are used with the
+// depth operand.
+type DepthIfc interface {
+	Depth() int
+}
+
+// HardlinksIfc must be implemented by any values that are used with the
+// nlink operand.
+type HardlinksIfc interface {
+	Hardlinks() uint64
+}
+
+type numericAttr int
+
+const (
+	sizeAttr numericAttr = iota
+	entriesAttr
+	depthAttr
+	nlinkAttr
+)
+
+func (na numericAttr) requires() reflect.Type {
+	switch na {
+	case sizeAttr:
+		return reflect.TypeOf((*matcher.FileSizeIfc)(nil)).Elem()
+	case entriesAttr:
+		return reflect.TypeOf((*matcher.DirSizeIfc)(nil)).Elem()
+	case depthAttr:
+		return reflect.TypeOf((*DepthIfc)(nil)).Elem()
+	}
+	return reflect.TypeOf((*HardlinksIfc)(nil)).Elem()
+}
+
+func (na numericAttr) value(v any) (int64, bool) {
+	switch na {
+	case sizeAttr:
+		typ, ok := v.(matcher.FileTypeIfc)
+		if !ok || !typ.Type().IsRegular() {
+			return 0, false
+		}
+		if s, ok := v.(matcher.FileSizeIfc); ok {
+			return s.Size(), true
+		}
+	case entriesAttr:
+		typ, ok := v.(matcher.FileTypeIfc)
+		if !ok || !typ.Type().IsDir() {
+			return 0, false
+		}
+		if s, ok := v.(matcher.DirSizeIfc); ok {
+			return s.NumEntries(), true
+		}
+	case depthAttr:
+		if d, ok := v.(DepthIfc); ok {
+			return int64(d.Depth()), true
+		}
+	case nlinkAttr:
+		if h, ok := v.(HardlinksIfc); ok {
+			return int64(h.Hardlinks()), true
+		}
+	}
+	return 0, false
+}
+
+var binarySizeSuffixes = map[byte]int64{
+	'k': 1 << 10,
+	'm': 1 << 20,
+	'g': 1 << 30,
+	't': 1 << 40,
+	'p': 1 << 50,
+}
+
+// parseSize parses a size that may use a single letter binary suffix,
+// eg. 4k or 10M, or the decimal (KB, MB etc) and binary (KiB, MiB etc)
+// suffixes supported by the file-larger and file-smaller operands.
+func parseSize(text string) (int64, error) {
+	if len(text) == 0 {
+		return 0, fmt.Errorf("missing size")
+	}
+	if n := len(text); n > 1 {
+		c := text[n-1]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if mult, ok := binarySizeSuffixes[c]; ok {
+			v, err := strconv.ParseFloat(text[:n-1], 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size: %q", text)
+			}
+			return int64(v * float64(mult)), nil
+		}
+	}
+	v, err := diskusage.ParseToBytes(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %q", text)
+	}
+	return int64(v), nil
+}
+
+// numericOperand compares a numeric attribute of a file or directory,
+// such as its size, with a value.
+type numericOperand struct {
+	name, text, document string
+	attr                 numericAttr
+	cmp                  string
+	value                int64
+}
+
+func newNumericOperand(name, value string, attr numericAttr, document string) boolexpr.Operand {
+	return numericOperand{
+		name:     name,
+		text:     value,
+		attr:     attr,
+		document: name + document,
+	}
+}
+
+func (op numericOperand) Prepare() (boolexpr.Operand, error) {
+	cmp, value := splitComparison(op.text)
+	if len(cmp) == 0 {
+		cmp = "="
+	}
+	var err error
+	if op.attr == sizeAttr {
+		op.value, err = parseSize(value)
+	} else {
+		op.value, err = strconv.ParseInt(value, 10, 64)
+	}
+	if err != nil {
+		return op, fmt.Errorf("%v: %v", op.name, err)
+	}
+	op.cmp = cmp
+	return op, nil
+}
+
+func (op numericOperand) Eval(v any) bool {
+	a, ok := op.attr.value(v)
+	if !ok {
+		return false
+	}
+	switch op.cmp {
+	case "<":
+		return a < op.value
+	case "<=":
+		return a <= op.value
+	case ">":
+		return a > op.value
+	case ">=":
+		return a >= op.value
+	}
+	return a == op.value
+}
+
+func (op numericOperand) Needs(t reflect.Type) bool {
+	return t.Implements(op.attr.requires())
+}
+
+func (op numericOperand) Document() string {
+	return op.document
+}
+
+func (op numericOperand) String() string {
+	return op.name + "=" + op.text
+}
+
+// registerNumericOperands registers the operands that compare numeric
+// attributes using any of <, <=, >, >= or =.
+func registerNumericOperands(parser *boolexpr.Parser) {
+	for _, op := range []struct {
+		name string
+		attr numericAttr
+		doc  string
+	}{
+		{"size", sizeAttr, `=<op><size> compares the size of a regular file, where <op> is one of <, <=, >, >= or =, eg. size>10MiB. The size may use the k, M, G, T or P binary suffixes or KiB/KB style binary and decimal suffixes`},
+		{"entries", entriesAttr, `=<op><n> compares the number of entries in a directory, as per dir-larger, eg. entries>=1000`},
+		{"depth", depthAttr, `=<op><n> compares the depth of a file or directory relative to the starting location, whose entries are at depth 1, eg. depth<3`},
+		{"nlink", nlinkAttr, `=<op><n> compares the number of hard links to a file, eg. nlink>1`},
+	} {
+		op := op
+		parser.RegisterOperand(op.name, func(n, v string) boolexpr.Operand {
+			return newNumericOperand(n, v, op.attr, op.doc)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		size int64
+	}{
+		{"100", 100},
+		{"4k", 4096},
+		{"4K", 4096},
+		{"1.5M", 1536 * 1024},
+		{"2G", 2 << 30},
+		{"10MiB", 10 << 20},
+		{"10MB", 10 * 1000 * 1000},
+		{"1KB", 1000},
+		{"1KiB", 1024},
+	} {
+		size, err := parseSize(tc.text)
+		if err != nil {
+			t.Errorf("%v: %v", tc.text, err)
+			continue
+		}
+		if got, want := size, tc.size; got != want {
+			t.Errorf("%v: got %v, want %v", tc.text, got, want)
+		}
+	}
+	for _, text := range []string{"", "k", "10x", "1.2.3M"} {
+		if _, err := parseSize(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+type numericTest struct {
+	mode       fs.FileMode
+	size       int64
+	numEntries int64
+	depth      int
+	nlink      uint64
+}
+
+func (nt numericTest) Type() fs.FileMode { return nt.mode.Type() }
+func (nt numericTest) Size() int64       { return nt.size }
+func (nt numericTest) NumEntries() int64 { return nt.numEntries }
+func (nt numericTest) Depth() int        { return nt.depth }
+func (nt numericTest) Hardlinks() uint64 { return nt.nlink }
+
+func TestNumericOperands(t *testing.T) {
+	f := numericTest{size: 10 << 20, depth: 2, nlink: 2}
+	d := numericTest{mode: fs.ModeDir, size: 4096, numEntries: 1000, depth: 1, nlink: 1}
+	for _, tc := range []struct {
+		expr string
+		f, d bool
+	}{
+		{"size>10M", false, false},
+		{"size>=10MiB", true, false},
+		{"size=10M", true, false},
+		{"size<=4k", false, false},
+		{"size<10MB", false, false},
+		{"size>10MB", true, false},
+		{"entries>=1000", false, true},
+		{"entries<1000", false, false},
+		{"entries=1000", false, true},
+		{"depth<2", false, true},
+		{"depth<=2", true, true},
+		{"depth=2", true, false},
+		{"nlink>1", true, false},
+		{"nlink==1", false, true},
+		{"(size>1M && depth>1) || entries>1", true, true},
+		{"file-larger=10MiB", true, false},
+	} {
+		expr := newExpr(t, tc.expr)
+		if got, want := expr.Eval(f), tc.f; got != want {
+			t.Errorf("%v: file: got %v, want %v", tc.expr, got, want)
+		}
+		if got, want := expr.Eval(d), tc.d; got != want {
+			t.Errorf("%v: dir: got %v, want %v", tc.expr, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		expr      string
+		needsStat bool
+	}{
+		{"size>1", true},
+		{"nlink>1", true},
+		{"depth<3", false},
+		{"entries>1", false},
+	} {
+		if got, want := newExpr(t, tc.expr).NeedsStat(), tc.needsStat; got != want {
+			t.Errorf("%v: got %v, want %v", tc.expr, got, want)
+		}
+	}
+
+	for _, expr := range []string{"size>10X", "depth<x", "entries>1k", "nlink>"} {
+		if _, err := createExpr([]string{expr}); err == nil {
+			t.Errorf("%v: expected an error", expr)
+		}
+	}
+}
+
+func TestNumericWalk(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		expr   string
+		output string
+	}{
+		{"depth<2", "r,r/d0,r/f0"},
+		{"depth=2", "r/d0/f1"},
+		{"size>15", "r/d0/f1"},
+		{"size<=10 || depth>=2", "r/d0/f1,r/f0"},
+	} {
+		for _, sorted := range []bool{false, true} {
+			lf := &locateFlags{Sorted: sorted, Depth: -1, Format: textFormat}
+			lf.ScanSize = 1
+			out, _ := locateOutput(ctx, t, lf, withSizesSpec, "r", tc.expr)
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			sort.Strings(lines)
+			if got, want := strings.Join(lines, ","), tc.output; got != want {
+				t.Errorf("%v: sorted %v: got %v, want %v", tc.expr, sorted, got, want)
+			}
+		}
+	}
+}
+
+const largeDirSpec = `
+name: r
+entries:
+  - dir:
+	  name: d0
+	  entries:
+		- file:
+			name: f0
+  - dir:
+	  name: d1
+  - file:
+	  name: f0
+  - file:
+	  name: f1
+  - file:
+	  name: f2
+`
+
+func TestExactDirSize(t *testing.T) {
+	ctx := context.Background()
+	for _, expr := range []string{"dir-larger=5", "entries>=5", "type=d && entries=5"} {
+		for _, sorted := range []bool{false, true} {
+			for _, scanSize := range []int{1, 2, 5, 10} {
+				for _, buffer := range []int{0, 2, 100} {
+					lf := &locateFlags{Sorted: sorted, Depth: -1, Format: textFormat,
+						ExactDirSize: true, ExactDirBuffer: buffer}
+					lf.ScanSize = scanSize
+					out, _ := locateOutput(ctx, t, lf, largeDirSpec, "r", expr)
+					lines := strings.Split(strings.TrimSpace(out), "\n")
+					sort.Strings(lines)
+					if got, want := strings.Join(lines, ","), "r/d0,r/d1"; got != want {
+						t.Errorf("%v: sorted %v, scan size %v, buffer %v: got %v, want %v", expr, sorted, scanSize, buffer, got, want)
+					}
+				}
+			}
+		}
+	}
+
+	// Without --exact-dir-size the directories are scanned before the
+	// number of entries reaches 5.
+	lf := &locateFlags{Sorted: true, Depth: -1, Format: textFormat}
+	lf.ScanSize = 1
+	if out, _ := locateOutput(ctx, t, lf, largeDirSpec, "r", "dir-larger=5"); len(out) != 0 {
+		t.Errorf("unexpected output: %v", out)
+	}
+}
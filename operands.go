@@ -41,19 +41,32 @@ func createExpr(input []string) (expression, error) {
 	parser := matcher.New()
 	parser.RegisterOperand("user", uid)
 	parser.RegisterOperand("group", gid)
+	registerTimeOperands(parser)
+	registerNumericOperands(parser)
+	contentOperands := registerContentOperands(parser)
+	numPruneOperands := registerPruneOperand(parser)
 
-	m := strings.TrimSpace(strings.Join(input, " "))
+	m := rewriteComparisons(strings.TrimSpace(strings.Join(input, " ")))
 	if len(m) == 0 {
 		return expression{parser: parser}, nil
 	}
 	expr, err := parser.Parse(m)
-	return expression{T: expr, parser: parser, isSet: true}, err
+	if err != nil {
+		return expression{}, err
+	}
+	content, err := prepareContentOperands(contentOperands())
+	if err != nil {
+		return expression{}, err
+	}
+	return expression{T: expr, parser: parser, isSet: true, content: content, pruneOperands: numPruneOperands()}, nil
 }
 
 type expression struct {
 	boolexpr.T
-	parser *boolexpr.Parser
-	isSet  bool
+	parser        *boolexpr.Parser
+	isSet         bool
+	content       []contentOperand
+	pruneOperands int
 }
 
 func (e expression) Eval(val any) bool {
@@ -72,10 +85,13 @@ func (e expression) EvalWithStat(val any) bool {
 
 type needsStat struct{}
 
-func (needsStat) ModTime() time.Time { return time.Time{} }
-func (needsStat) Mode() fs.FileMode  { return 0 }
-func (needsStat) Size() int64        { return 0 }
-func (needsStat) XAttr() file.XAttr  { return file.XAttr{} }
+func (needsStat) ModTime() time.Time    { return time.Time{} }
+func (needsStat) Mode() fs.FileMode     { return 0 }
+func (needsStat) Size() int64           { return 0 }
+func (needsStat) XAttr() file.XAttr     { return file.XAttr{} }
+func (needsStat) AccessTime() time.Time { return time.Time{} }
+func (needsStat) ChangeTime() time.Time { return time.Time{} }
+func (needsStat) Hardlinks() uint64     { return 0 }
 
 // NeedsStat determines if either of the supplied boolexpr.T's include
 // operands that would require a call to fs.Stat or fs.Lstat.
@@ -94,10 +110,39 @@ func (e expression) NeedsNumEntries() bool {
 	return e.T.Needs(numEntries{})
 }
 
+type needsUnindexed struct{}
+
+func (needsUnindexed) AccessTime() time.Time { return time.Time{} }
+func (needsUnindexed) ChangeTime() time.Time { return time.Time{} }
+func (needsUnindexed) Hardlinks() uint64     { return 0 }
+
+// unindexedOperands describes the operands that require information that
+// is not recorded in an index, see NeedsUnindexed.
+const unindexedOperands = "the atime, ctime, accessed-*, changed-* and nlink operands"
+
+// NeedsUnindexed determines if the supplied boolexpr.T's include operands
+// that require information that is not recorded in an index.
+func (e expression) NeedsUnindexed() bool {
+	return e.T.Needs(needsUnindexed{})
+}
+
+type needsContent struct{}
+
+func (needsContent) probeContent(int) (bool, bool) { return false, false }
+
+// NeedsContent determines if the supplied boolexpr.T's include
+// operands that may require reading the contents of files.
+func (e expression) NeedsContent() bool {
+	return e.T.Needs(needsContent{})
+}
+
 type entryType struct {
 	name, path string
 	mode       fs.FileMode
 	numEntries int64
+	depth      int
+	content    *fileContent
+	prune      bool
 }
 
 func (wn entryType) Name() string {
@@ -116,12 +161,41 @@ func (wn entryType) NumEntries() int64 {
 	return wn.numEntries
 }
 
+func (wn entryType) Depth() int {
+	return wn.depth
+}
+
+func (wn entryType) probeContent(index int) (bool, bool) {
+	return wn.content.probeResult(index)
+}
+
+func (wn entryType) isRegular() bool {
+	return wn.mode.IsRegular()
+}
+
+func (wn entryType) withContent(fc *fileContent) any {
+	wn.content = fc
+	return wn
+}
+
+func (wn entryType) Prune() bool {
+	return wn.prune
+}
+
+func (wn entryType) withPrune(p bool) any {
+	wn.prune = p
+	return wn
+}
+
 type withStat struct {
 	ctx        context.Context
 	name, path string
 	fs         filewalk.FS
 	info       file.Info
 	numEntries int64
+	depth      int
+	content    *fileContent
+	prune      bool
 }
 
 func (ws withStat) Name() string {
@@ -136,6 +210,16 @@ func (ws withStat) ModTime() time.Time {
 	return ws.info.ModTime()
 }
 
+func (ws withStat) AccessTime() time.Time {
+	t, _ := accessTimeFromSys(ws.info.Sys())
+	return t
+}
+
+func (ws withStat) ChangeTime() time.Time {
+	t, _ := changeTimeFromSys(ws.info.Sys())
+	return t
+}
+
 func (ws withStat) Mode() fs.FileMode {
 	return ws.info.Mode()
 }
@@ -152,7 +236,37 @@ func (ws withStat) NumEntries() int64 {
 	return ws.numEntries
 }
 
+func (ws withStat) Depth() int {
+	return ws.depth
+}
+
+func (ws withStat) probeContent(index int) (bool, bool) {
+	return ws.content.probeResult(index)
+}
+
+func (ws withStat) isRegular() bool {
+	return ws.info.Mode().IsRegular()
+}
+
+func (ws withStat) withContent(fc *fileContent) any {
+	ws.content = fc
+	return ws
+}
+
+func (ws withStat) Prune() bool {
+	return ws.prune
+}
+
+func (ws withStat) withPrune(p bool) any {
+	ws.prune = p
+	return ws
+}
+
 func (ws withStat) XAttr() file.XAttr {
 	xattr, _ := ws.fs.XAttr(ws.ctx, ws.path, ws.info)
 	return xattr
 }
+
+func (ws withStat) Hardlinks() uint64 {
+	return ws.XAttr().Hardlinks
+}
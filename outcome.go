@@ -0,0 +1,206 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"cloudeng.io/file"
+)
+
+// The exit status of the locate command.
+const (
+	exitNoMatches = 1
+	exitErrors    = 2
+)
+
+// errSearchErrors is returned when a search completes but errors, other
+// than those suppressed by --quiet-errors, were encountered.
+var errSearchErrors = errors.New("search completed with errors")
+
+// errInterrupted is returned when a search is interrupted, along with any
+// other errors encountered.
+var errInterrupted = errors.New("interrupted")
+
+// errorClasses are the classes of error that errors encountered during
+// a search are grouped into.
+var errorClasses = []string{"permission", "notexist", "loop", "io", "throttled", "canceled", "other"}
+
+// apiError is implemented by errors returned by the AWS APIs.
+type apiError interface {
+	ErrorCode() string
+}
+
+// classifyError returns the class of an error reported for a file or
+// directory, as used to group errors.
+func classifyError(fsys file.FS, err error) string {
+	var apiErr apiError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException", "RequestThrottled":
+			return "throttled"
+		case "AccessDenied", "AllAccessDisabled", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken":
+			return "permission"
+		}
+	}
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case fsys != nil && fsys.IsPermissionError(err), errors.Is(err, fs.ErrPermission):
+		return "permission"
+	case fsys != nil && fsys.IsNotExist(err), errors.Is(err, fs.ErrNotExist):
+		// Includes files and directories deleted whilst being searched.
+		return "notexist"
+	case errors.Is(err, syscall.ELOOP):
+		return "loop"
+	case errors.Is(err, syscall.EIO):
+		return "io"
+	}
+	return "other"
+}
+
+// parseErrorClasses parses the value of --quiet-errors, a comma
+// separated list of error classes.
+func parseErrorClasses(spec string) (map[string]bool, error) {
+	classes := map[string]bool{}
+	if len(spec) == 0 {
+		return classes, nil
+	}
+	for _, c := range strings.Split(spec, ",") {
+		c = strings.TrimSpace(c)
+		if !slices.Contains(errorClasses, c) {
+			return nil, fmt.Errorf("unsupported --quiet-errors class: %q, use a comma separated list of %v", c, strings.Join(errorClasses, ", "))
+		}
+		classes[c] = true
+	}
+	return classes, nil
+}
+
+// maxErrorExamples is the number of paths displayed for each class of
+// error in the summary of the errors encountered.
+const maxErrorExamples = 3
+
+type errorGroup struct {
+	count    int64
+	examples []string
+}
+
+// outcome records the number of matches found and the errors, grouped by
+// class, encountered by a search in order to summarize the errors and to
+// determine the exit status once the search is complete.
+type outcome struct {
+	quiet   map[string]bool
+	matches atomic.Int64
+
+	mu     sync.Mutex
+	errors map[string]*errorGroup
+}
+
+func newOutcome(quiet map[string]bool) *outcome {
+	return &outcome{quiet: quiet, errors: map[string]*errorGroup{}}
+}
+
+func (o *outcome) matched() {
+	if o == nil {
+		return
+	}
+	o.matches.Add(1)
+}
+
+// error records an error of the specified class and returns true if it
+// should be displayed, ie. it has not been suppressed by --quiet-errors.
+func (o *outcome) error(class, path string) bool {
+	if o == nil {
+		return true
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	g := o.errors[class]
+	if g == nil {
+		g = &errorGroup{}
+		o.errors[class] = g
+	}
+	g.count++
+	if len(g.examples) < maxErrorExamples {
+		g.examples = append(g.examples, path)
+	}
+	return !o.quiet[class]
+}
+
+// mergeErrors records the errors recorded by other, but not its matches,
+// in o.
+func (o *outcome) mergeErrors(other *outcome) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for class, og := range other.errors {
+		g := o.errors[class]
+		if g == nil {
+			g = &errorGroup{}
+			o.errors[class] = g
+		}
+		g.count += og.count
+		for _, path := range og.examples {
+			if len(g.examples) < maxErrorExamples {
+				g.examples = append(g.examples, path)
+			}
+		}
+	}
+}
+
+// summarize displays the number of errors encountered for each class
+// along with the first few paths for which they were encountered.
+func (o *outcome) summarize(out *output) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	classes := make([]string, 0, len(o.errors))
+	for class := range o.errors {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		g := o.errors[class]
+		suffix := ""
+		if g.count > int64(len(g.examples)) {
+			suffix = ", ..."
+		}
+		quiet := ""
+		if o.quiet[class] {
+			quiet = " (not displayed)"
+		}
+		out.message("errors: %v: %v%v: %v%v", class, g.count, quiet, strings.Join(g.examples, ", "), suffix)
+	}
+}
+
+// err returns errSearchErrors if any errors that were not suppressed by
+// --quiet-errors were encountered, errNoMatches if there were no matches
+// and nil otherwise.
+func (o *outcome) err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var n int64
+	for class, g := range o.errors {
+		if !o.quiet[class] {
+			n += g.count
+		}
+	}
+	if n > 0 {
+		return errSearchErrors
+	}
+	if o.matches.Load() == 0 {
+		return errNoMatches
+	}
+	return nil
+}
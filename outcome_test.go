@@ -0,0 +1,132 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"cloudeng.io/file/localfs"
+)
+
+type testAPIError string
+
+func (e testAPIError) Error() string     { return string(e) }
+func (e testAPIError) ErrorCode() string { return string(e) }
+
+func TestClassifyError(t *testing.T) {
+	fs := localfs.New()
+	_, err := fs.Stat(context.Background(), "/does/not/exist")
+	for _, tc := range []struct {
+		err   error
+		class string
+	}{
+		{err, "notexist"},
+		{os.ErrPermission, "permission"},
+		{&os.PathError{Op: "open", Path: "x", Err: syscall.ELOOP}, "loop"},
+		{&os.PathError{Op: "read", Path: "x", Err: syscall.EIO}, "io"},
+		{fmt.Errorf("list: %w", testAPIError("SlowDown")), "throttled"},
+		{fmt.Errorf("list: %w", testAPIError("AccessDenied")), "permission"},
+		{fmt.Errorf("scan: %w", context.Canceled), "canceled"},
+		{fmt.Errorf("oops"), "other"},
+	} {
+		if got, want := classifyError(fs, tc.err), tc.class; got != want {
+			t.Errorf("%v: got %v, want %v", tc.err, got, want)
+		}
+	}
+	if _, err := parseErrorClasses("permission, notexist"); err != nil {
+		t.Error(err)
+	}
+	if _, err := parseErrorClasses("permission,nope"); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("missing or wrong error: %v", err)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	ctx := context.Background()
+	fs := localfs.New()
+	for _, sorted := range []bool{false, true} {
+		for _, tc := range []struct {
+			quiet, expr string
+			displayed   int
+			err         error
+		}{
+			{"", "", 2, errSearchErrors},
+			{"permission", "", 0, nil},
+			{"permission", "newer=2050-12-13", 0, errNoMatches},
+			{"notexist,permission", "type=f", 0, nil},
+		} {
+			lf := &locateFlags{Sorted: sorted, Depth: -1, QuietErrors: tc.quiet}
+			lf.ScanSize = 100
+			quiet, err := parseErrorClasses(lf.QuietErrors)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out, errs bytes.Buffer
+			o := newOutput(&out, &errs, textFormat, false)
+			v := visit{ctx: ctx, fs: fs, lf: lf, out: o, roots: []string{localTestTree}, outcome: newOutcome(quiet)}
+			args := []string{localTestTree, "--"}
+			if len(tc.expr) > 0 {
+				args = append(args, tc.expr)
+			}
+			if err := (locateCmd{}).locateFS(ctx, fs, lf, v.visit, args); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.Count(errs.String(), "\n"), tc.displayed; got != want {
+				t.Errorf("sorted %v, quiet %q: errors displayed: got %v, want %v: %v", sorted, tc.quiet, got, want, errs.String())
+			}
+			if err := v.outcome.err(); !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+				t.Errorf("sorted %v, quiet %q: got %v, want %v", sorted, tc.quiet, err, tc.err)
+			}
+
+			errs.Reset()
+			v.outcome.summarize(o)
+			summary := errs.String()
+			suffix := ""
+			if len(tc.quiet) > 0 {
+				suffix = " (not displayed)"
+			}
+			if got, want := summary, "errors: permission: 2"+suffix+": "; !strings.HasPrefix(got, want) {
+				t.Errorf("sorted %v, quiet %q: got %q, want prefix %q", sorted, tc.quiet, got, want)
+			}
+			for _, p := range []string{"/inaccessible-dir", "/a0/inaccessible-dir"} {
+				if !strings.Contains(summary, localTestTree+p) {
+					t.Errorf("sorted %v, quiet %q: %v missing from %q", sorted, tc.quiet, p, summary)
+				}
+			}
+		}
+	}
+}
+
+func TestOutcomeMergeErrors(t *testing.T) {
+	walked := newOutcome(nil)
+	walked.matched()
+	for _, p := range []string{"a", "b", "c", "d"} {
+		walked.error("permission", p)
+	}
+	oc := newOutcome(nil)
+	oc.matched()
+	oc.error("permission", "x")
+	oc.mergeErrors(walked)
+	if got, want := oc.matches.Load(), int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	g := oc.errors["permission"]
+	if got, want := g.count, int64(5); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := strings.Join(g.examples, ","), "x,a,b"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := oc.err(); !errors.Is(err, errSearchErrors) {
+		t.Errorf("got %v, want %v", err, errSearchErrors)
+	}
+}
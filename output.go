@@ -0,0 +1,271 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	"cloudeng.io/file"
+)
+
+const (
+	textFormat   = "text"
+	jsonFormat   = "json"
+	ndjsonFormat = "ndjson"
+)
+
+func validateFormat(format string) error {
+	switch format {
+	case textFormat, jsonFormat, ndjsonFormat:
+		return nil
+	}
+	return fmt.Errorf("unsupported output format: %q, use one of %v, %v or %v", format, textFormat, jsonFormat, ndjsonFormat)
+}
+
+// record represents a single match when displayed as json.
+type record struct {
+	Path   string `json:"path"`
+	Parent string `json:"parent"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	*statRecord
+}
+
+// statRecord contains the information obtained via stat/lstat
+// and is only displayed if that information is available.
+type statRecord struct {
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	UID     int64     `json:"uid"`
+	GID     int64     `json:"gid"`
+	User    string    `json:"user,omitempty"`
+	Group   string    `json:"group,omitempty"`
+	Device  uint64    `json:"device"`
+	Inode   uint64    `json:"inode"`
+}
+
+// errorRecord represents an error when displayed as json.
+type errorRecord struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// typeLetter returns the find(1) style single letter used to
+// describe the type of a file.
+func typeLetter(mode fs.FileMode) string {
+	switch mode.Type() {
+	case 0:
+		return "f"
+	case fs.ModeDir:
+		return "d"
+	case fs.ModeSymlink:
+		return "l"
+	case fs.ModeNamedPipe:
+		return "p"
+	case fs.ModeSocket:
+		return "s"
+	case fs.ModeDevice:
+		return "b"
+	case fs.ModeDevice | fs.ModeCharDevice:
+		return "c"
+	}
+	return "?"
+}
+
+func newStatRecord(fi *file.Info, xattr file.XAttr, user, group string) *statRecord {
+	return &statRecord{
+		Size:    fi.Size(),
+		Mode:    fi.Mode().String(),
+		ModTime: fi.ModTime(),
+		UID:     xattr.UID,
+		GID:     xattr.GID,
+		User:    user,
+		Group:   group,
+		Device:  xattr.Device,
+		Inode:   xattr.FileID,
+	}
+}
+
+// output is used to display matches and errors, it serializes all
+// writes so that it may be used by concurrent walkers without
+// interleaving output.
+type output struct {
+	mu       sync.Mutex
+	out      io.Writer
+	errs     io.Writer
+	format   string
+	eol      string
+	nRecords int
+	// status is true when a status line, such as that displayed by
+	// --progress, is displayed on a terminal and must be cleared before
+	// anything else is written.
+	status bool
+}
+
+// newOutput creates a new output, if print0 is true then text output,
+// including errors and messages, is terminated by a NUL rather than a
+// newline.
+func newOutput(out, errs io.Writer, format string, print0 bool) *output {
+	o := &output{out: out, errs: errs, format: format, eol: "\n"}
+	if print0 {
+		o.eol = "\x00"
+	}
+	return o
+}
+
+// begin must be called before any matches are displayed.
+func (o *output) begin() {
+	if o.format == jsonFormat {
+		fmt.Fprint(o.out, "[")
+	}
+}
+
+// end must be called once all matches have been displayed.
+func (o *output) end() {
+	if o.format != jsonFormat {
+		return
+	}
+	if o.nRecords > 0 {
+		fmt.Fprint(o.out, "\n")
+	}
+	fmt.Fprint(o.out, "]\n")
+}
+
+func (o *output) text(line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.clearStatus()
+	io.WriteString(o.out, line)  //nolint:errcheck
+	io.WriteString(o.out, o.eol) //nolint:errcheck
+}
+
+// raw writes the supplied string as is, with no added newline.
+func (o *output) raw(s string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.clearStatus()
+	io.WriteString(o.out, s) //nolint:errcheck
+}
+
+// commandOutput writes the output of a command run for one or more
+// matches in its entirety.
+func (o *output) commandOutput(stdout, stderr []byte) {
+	if len(stdout) == 0 && len(stderr) == 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.clearStatus()
+	o.out.Write(stdout)  //nolint:errcheck
+	o.errs.Write(stderr) //nolint:errcheck
+}
+
+// message displays an informational message on the error stream,
+// terminated as per errors.
+func (o *output) message(format string, args ...any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.clearStatus()
+	fmt.Fprintf(o.errs, format, args...)
+	io.WriteString(o.errs, o.eol) //nolint:errcheck
+}
+
+// logObject displays an arbitrary value as a single line of json on the
+// error stream.
+func (o *output) logObject(v any) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.clearStatus()
+	o.errs.Write(buf) //nolint:errcheck
+	fmt.Fprint(o.errs, "\n")
+}
+
+func (o *output) record(r record) {
+	o.object(r.Path, r)
+}
+
+// object displays an arbitrary value, such as the usage of a directory,
+// as json, any errors are reported against the supplied path.
+func (o *output) object(path string, v any) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		o.error(path, err)
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.clearStatus()
+	if o.format == jsonFormat {
+		if o.nRecords > 0 {
+			fmt.Fprint(o.out, ",")
+		}
+		fmt.Fprint(o.out, "\n")
+	}
+	o.out.Write(buf) //nolint:errcheck
+	if o.format == ndjsonFormat {
+		fmt.Fprint(o.out, "\n")
+	}
+	o.nRecords++
+}
+
+// error displays an error on the error stream, as ndjson for
+// either of the json formats.
+func (o *output) error(path string, err error) {
+	if o.format == textFormat {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.clearStatus()
+		fmt.Fprintf(o.errs, "%v: %v%s", path, err, o.eol)
+		return
+	}
+	buf, merr := json.Marshal(errorRecord{Path: path, Error: err.Error()})
+	if merr != nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.clearStatus()
+	o.errs.Write(buf) //nolint:errcheck
+	fmt.Fprint(o.errs, "\n")
+}
+
+// clearStatus clears the status line, if any, it must be called with
+// mu held.
+func (o *output) clearStatus() {
+	if o.status {
+		io.WriteString(o.errs, "\r\x1b[K") //nolint:errcheck
+		o.status = false
+	}
+}
+
+// setStatus displays a single line of status on the error stream of a
+// terminal, replacing any existing status line. The line is cleared
+// before anything else is written so that it never interleaves with
+// matches or errors.
+func (o *output) setStatus(line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	io.WriteString(o.errs, "\r")     //nolint:errcheck
+	io.WriteString(o.errs, line)     //nolint:errcheck
+	io.WriteString(o.errs, "\x1b[K") //nolint:errcheck
+	o.status = true
+}
+
+// endStatus clears the status line, if any.
+func (o *output) endStatus() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.clearStatus()
+}
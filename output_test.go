@@ -0,0 +1,206 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloudeng.io/file/filewalk/filewalktestutil"
+)
+
+const withSizesSpec = `
+name: r
+device: 30
+file_id: 40
+entries:
+  - file:
+	  name: f0
+	  size: 10
+	  uid: 100
+	  gid: 200
+	  device: 30
+	  file_id: 31
+  - dir:
+	  name: d0
+	  device: 30
+	  file_id: 41
+	  entries:
+		- file:
+			name: f1
+			size: 20
+			uid: 101
+			gid: 201
+			device: 30
+			file_id: 42
+`
+
+func locateOutput(ctx context.Context, t *testing.T, lf *locateFlags, spec string, args ...string) (stdout, stderr string) {
+	fs, err := filewalktestutil.NewMockFS("r", filewalktestutil.WithYAMLConfig(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := newPrintfFormat(lf.Printf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, errs bytes.Buffer
+	o := newOutput(&out, &errs, lf.Format, lf.Print0)
+	v := visit{ctx: ctx, fs: fs, lf: lf, out: o, roots: args[:1], printf: pf}
+	if len(lf.Exec) > 0 {
+		v.exec, err = newExecRunner(ctx, lf.Exec, lf.ExecConcurrency, o, v.error)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lf.Top > 0 {
+		if v.top, err = newTopMatches(lf.Top, lf.By); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(lf.SortBy) > 0 {
+		if v.sorter, err = newMatchSorter(lf.SortBy, lf.SortBuffer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o.begin()
+	opts := append([]walkerOption{flagExclusions(t, lf)}, v.options()...)
+	err = (locateCmd{}).locateFS(ctx, fs, lf, v.visit, args, opts...)
+	if err := v.finish(err); err != nil {
+		t.Fatal(err)
+	}
+	o.end()
+	return out.String(), errs.String()
+}
+
+// jsonRecord is used to decode the json output since record embeds
+// an unexported pointer type.
+type jsonRecord struct {
+	Path   string `json:"path"`
+	Parent string `json:"parent"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	statRecord
+}
+
+func TestJSONOutput(t *testing.T) {
+	ctx := context.Background()
+	expected := map[string]jsonRecord{
+		"r": {Path: "r", Parent: "", Name: "r", Type: "d",
+			statRecord: statRecord{Device: 30, Inode: 40}},
+		"r/f0": {Path: "r/f0", Parent: "r", Name: "f0", Type: "f",
+			statRecord: statRecord{Size: 10, UID: 100, GID: 200, Device: 30, Inode: 31}},
+		"r/d0": {Path: "r/d0", Parent: "r", Name: "d0", Type: "d",
+			statRecord: statRecord{Device: 30, Inode: 41}},
+		"r/d0/f1": {Path: "r/d0/f1", Parent: "r/d0", Name: "f1", Type: "f",
+			statRecord: statRecord{Size: 20, UID: 101, GID: 201, Device: 30, Inode: 42}},
+	}
+
+	cmp := func(records []jsonRecord) {
+		t.Helper()
+		if got, want := len(records), len(expected); got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for _, r := range records {
+			want, ok := expected[r.Path]
+			if !ok {
+				t.Errorf("unexpected record: %v", r.Path)
+				continue
+			}
+			r.Mode, r.User, r.Group = "", "", ""
+			if got := r; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		}
+	}
+
+	for _, sorted := range []bool{false, true} {
+		lf := &locateFlags{Sorted: sorted, Depth: -1, Format: ndjsonFormat}
+		lf.ScanSize = 100
+		out, errs := locateOutput(ctx, t, lf, withSizesSpec, "r")
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors: %v", errs)
+		}
+		var records []jsonRecord
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			var r jsonRecord
+			if err := json.Unmarshal([]byte(line), &r); err != nil {
+				t.Fatalf("%v: %v", line, err)
+			}
+			records = append(records, r)
+		}
+		cmp(records)
+
+		lf.Format = jsonFormat
+		out, _ = locateOutput(ctx, t, lf, withSizesSpec, "r")
+		records = nil
+		if err := json.Unmarshal([]byte(out), &records); err != nil {
+			t.Fatalf("%v: %v", out, err)
+		}
+		cmp(records)
+
+		out, _ = locateOutput(ctx, t, lf, withSizesSpec, "r", "name=nomatch")
+		if got, want := out, "[]\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPrint0(t *testing.T) {
+	ctx := context.Background()
+	for _, sorted := range []bool{false, true} {
+		for _, long := range []bool{false, true} {
+			lf := &locateFlags{Sorted: sorted, Long: long, Depth: -1, Format: textFormat, Print0: true}
+			lf.ScanSize = 100
+			out, _ := locateOutput(ctx, t, lf, withSizesSpec, "r", "type=f")
+			if strings.Contains(out, "\n") {
+				t.Errorf("unexpected newline in %q", out)
+			}
+			paths := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+			sort.Strings(paths)
+			if !long {
+				if got, want := strings.Join(paths, ","), "r/d0/f1,r/f0"; got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			}
+			if got, want := len(paths), 2; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	}
+	var out, errs bytes.Buffer
+	o := newOutput(&out, &errs, textFormat, true)
+	o.error("a\nb", errTest("oops"))
+	if got, want := errs.String(), "a\nb: oops\x00"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	errs.Reset()
+	o.message("errors: %v: %v", "other", 1)
+	if got, want := errs.String(), "errors: other: 1\x00"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	var out, errs bytes.Buffer
+	o := newOutput(&out, &errs, ndjsonFormat, false)
+	o.error("a/b", errTest("oops"))
+	var r errorRecord
+	if err := json.Unmarshal(errs.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r, (errorRecord{Path: "a/b", Error: "oops"}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+type errTest string
+
+func (e errTest) Error() string { return string(e) }
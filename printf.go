@@ -0,0 +1,295 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudeng.io/file"
+)
+
+// printfDirective represents either a literal string or a single
+// find(1) style % directive, with an optional field width.
+type printfDirective struct {
+	literal   string
+	verb      byte
+	timeField byte
+	width     int
+	leftAlign bool
+}
+
+// printfFormat represents a parsed find(1) style -printf format.
+type printfFormat []printfDirective
+
+// printfStatVerbs are the verbs that require information obtained via
+// stat/lstat.
+const printfStatVerbs = "sbkmMuUgGinDtT"
+
+// printfXAttrVerbs are the verbs that require the file.XAttr for a match.
+const printfXAttrVerbs = "bkuUgGinD"
+
+// printfUnindexedVerbs are the verbs that require information that is
+// not recorded in an index.
+const printfUnindexedVerbs = "bkn"
+
+var printfTimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'B': "January",
+	'c': time.ANSIC,
+	'd': "02",
+	'D': "01/02/06",
+	'F': "2006-01-02",
+	'h': "Jan",
+	'H': "15",
+	'I': "03",
+	'j': "002",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'r': "03:04:05 PM",
+	'S': "05",
+	'T': "15:04:05",
+	'x': "01/02/06",
+	'X': "15:04:05",
+	'y': "06",
+	'Y': "2006",
+	'Z': "MST",
+	'+': "2006-01-02+15:04:05",
+}
+
+const printfDoc = `The --printf format is modeled on that of find(1) and supports the following directives:
+
+  %p path, %P path relative to the starting location, %f name,
+  %h the directory containing the match, %H the starting location,
+  %y type (f, d, l, p, s, b, c), %s size in bytes,
+  %b size in 512 byte blocks, %k size in 1K blocks,
+  %m permissions in octal, %M permissions in symbolic form,
+  %u user name, %U user id, %g group name, %G group id,
+  %i inode, %D device, %n number of hard links,
+  %t modification time in ctime format, %T<k> field k of the modification
+  time where k is one of @ (seconds since the epoch), a, A, b, B, c, d,
+  D, F, h, H, I, j, m, M, p, r, S, T, x, X, y, Y, Z or +,
+  %% a literal %.
+
+A field width may be specified, as in %10s or %-10s. The escapes \n, \t, \r,
+\0 and \\ are also supported. Note that no newline is added unless
+explicitly requested.
+`
+
+func newPrintfFormat(format string) (printfFormat, error) {
+	var pf printfFormat
+	var lit strings.Builder
+	appendLiteral := func() {
+		if lit.Len() > 0 {
+			pf = append(pf, printfDirective{literal: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch c {
+		case '\\':
+			i++
+			if i >= len(format) {
+				return nil, fmt.Errorf("printf format %q: missing escaped character", format)
+			}
+			switch format[i] {
+			case 'n':
+				lit.WriteByte('\n')
+			case 't':
+				lit.WriteByte('\t')
+			case 'r':
+				lit.WriteByte('\r')
+			case '0':
+				lit.WriteByte(0)
+			case '\\':
+				lit.WriteByte('\\')
+			default:
+				return nil, fmt.Errorf("printf format %q: unsupported escape \\%c", format, format[i])
+			}
+		case '%':
+			d, n, err := parsePrintfDirective(format[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("printf format %q: %v", format, err)
+			}
+			i += n
+			if d.verb == '%' {
+				lit.WriteByte('%')
+				continue
+			}
+			appendLiteral()
+			pf = append(pf, d)
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	appendLiteral()
+	return pf, nil
+}
+
+func parsePrintfDirective(format string) (printfDirective, int, error) {
+	var d printfDirective
+	i := 0
+	if i < len(format) && format[i] == '-' {
+		d.leftAlign = true
+		i++
+	}
+	start := i
+	for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+		i++
+	}
+	if i > start {
+		d.width, _ = strconv.Atoi(format[start:i])
+	}
+	if i >= len(format) {
+		return d, i, fmt.Errorf("incomplete directive")
+	}
+	d.verb = format[i]
+	switch {
+	case d.verb == '%':
+	case d.verb == 'T':
+		i++
+		if i >= len(format) {
+			return d, i, fmt.Errorf("missing time field for %%T")
+		}
+		d.timeField = format[i]
+		if _, ok := printfTimeLayouts[d.timeField]; !ok && d.timeField != '@' {
+			return d, i, fmt.Errorf("unsupported time field %%T%c", d.timeField)
+		}
+	case strings.IndexByte("pPfhHystbkmMuUgGinD", d.verb) >= 0:
+	default:
+		return d, i, fmt.Errorf("unsupported directive %%%c", d.verb)
+	}
+	return d, i + 1, nil
+}
+
+func (pf printfFormat) needs(verbs string) bool {
+	for _, d := range pf {
+		if d.verb != 0 && strings.IndexByte(verbs, d.verb) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// needsStat returns true if the format refers to any information
+// that requires a stat/lstat call.
+func (pf printfFormat) needsStat() bool {
+	return pf.needs(printfStatVerbs)
+}
+
+// needsXAttr returns true if the format refers to any information
+// that requires the file.XAttr for a match.
+func (pf printfFormat) needsXAttr() bool {
+	return pf.needs(printfXAttrVerbs)
+}
+
+// needsUnindexed returns true if the format requires information that
+// is not recorded in an index.
+func (pf printfFormat) needsUnindexed() bool {
+	return pf.needs(printfUnindexedVerbs)
+}
+
+// printfMatch contains the information available for a match
+// when formatting it using a printfFormat.
+type printfMatch struct {
+	path, parent, name, root string
+	typ                      string
+	fi                       *file.Info
+	xattr                    file.XAttr
+	user, group              string
+}
+
+func (pf printfFormat) format(m printfMatch) string {
+	var out strings.Builder
+	for _, d := range pf {
+		if d.verb == 0 {
+			out.WriteString(d.literal)
+			continue
+		}
+		v := d.value(m)
+		switch {
+		case d.width == 0:
+			out.WriteString(v)
+		case d.leftAlign:
+			fmt.Fprintf(&out, "%-*s", d.width, v)
+		default:
+			fmt.Fprintf(&out, "%*s", d.width, v)
+		}
+	}
+	return out.String()
+}
+
+// relativePath returns path relative to root, or path itself if it
+// is not below root.
+func relativePath(root, path string) string {
+	if len(root) == 0 || !hasPathPrefix(path, root) {
+		return path
+	}
+	return strings.TrimLeft(path[len(root):], "/\\")
+}
+
+func (d printfDirective) value(m printfMatch) string {
+	switch d.verb {
+	case 'p':
+		return m.path
+	case 'P':
+		return relativePath(m.root, m.path)
+	case 'f':
+		return m.name
+	case 'h':
+		return m.parent
+	case 'H':
+		return m.root
+	case 'y':
+		return m.typ
+	}
+	if m.fi == nil {
+		return ""
+	}
+	switch d.verb {
+	case 's':
+		return strconv.FormatInt(m.fi.Size(), 10)
+	case 'b':
+		return strconv.FormatInt(m.xattr.Blocks, 10)
+	case 'k':
+		return strconv.FormatInt((m.xattr.Blocks+1)/2, 10)
+	case 'm':
+		return strconv.FormatUint(uint64(m.fi.Mode().Perm()), 8)
+	case 'M':
+		return m.fi.Mode().String()
+	case 'u':
+		return m.user
+	case 'U':
+		return strconv.FormatInt(m.xattr.UID, 10)
+	case 'g':
+		return m.group
+	case 'G':
+		return strconv.FormatInt(m.xattr.GID, 10)
+	case 'i':
+		return strconv.FormatUint(m.xattr.FileID, 10)
+	case 'n':
+		return strconv.FormatUint(m.xattr.Hardlinks, 10)
+	case 'D':
+		return strconv.FormatUint(m.xattr.Device, 10)
+	case 't':
+		return m.fi.ModTime().Format(time.ANSIC)
+	case 'T':
+		return formatTimeField(m.fi.ModTime(), d.timeField)
+	}
+	return ""
+}
+
+func formatTimeField(t time.Time, field byte) string {
+	if field == '@' {
+		return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+	}
+	return t.Format(printfTimeLayouts[field])
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/localfs"
+)
+
+func TestPrintfFormat(t *testing.T) {
+	when := time.Date(2023, 4, 5, 6, 7, 8, 9, time.UTC)
+	fi := file.NewInfo("f0", 1234, 0640, when, nil)
+	m := printfMatch{
+		path:   "/a/b/f0",
+		parent: "/a/b",
+		name:   "f0",
+		root:   "/a",
+		typ:    "f",
+		fi:     &fi,
+		xattr:  file.XAttr{UID: 10, GID: 20, FileID: 30, Device: 40, Blocks: 8, Hardlinks: 2},
+		user:   "u",
+		group:  "g",
+	}
+	for _, tc := range []struct {
+		format, output    string
+		needsStat, xattrs bool
+	}{
+		{`%p\n`, "/a/b/f0\n", false, false},
+		{`%P %f %h %H %y`, "b/f0 f0 /a/b /a f", false, false},
+		{`%s %p`, "1234 /a/b/f0", true, false},
+		{`%10s|%-4s|`, "      1234|1234|", true, false},
+		{`%m %M`, "640 -rw-r-----", true, false},
+		{`%u %U %g %G`, "u 10 g 20", true, true},
+		{`%i %D %n %b %k`, "30 40 2 8 4", true, true},
+		{`%TY-%Tm-%Td %TT %T@`, "2023-04-05 06:07:08 1680674828.000000009", true, false},
+		{`%t`, "Wed Apr  5 06:07:08 2023", true, false},
+		{`100%%\t%f\0`, "100%\tf0\x00", false, false},
+	} {
+		pf, err := newPrintfFormat(tc.format)
+		if err != nil {
+			t.Errorf("%v: %v", tc.format, err)
+			continue
+		}
+		if got, want := pf.format(m), tc.output; got != want {
+			t.Errorf("%v: got %q, want %q", tc.format, got, want)
+		}
+		if got, want := pf.needsStat(), tc.needsStat; got != want {
+			t.Errorf("%v: got %v, want %v", tc.format, got, want)
+		}
+		if got, want := pf.needsXAttr(), tc.xattrs; got != want {
+			t.Errorf("%v: got %v, want %v", tc.format, got, want)
+		}
+	}
+
+	for _, format := range []string{`%`, `%q`, `%T`, `%TQ`, `\q`, `\`} {
+		if _, err := newPrintfFormat(format); err == nil {
+			t.Errorf("%v: expected an error", format)
+		}
+	}
+}
+
+func TestPrintfOutput(t *testing.T) {
+	ctx := context.Background()
+	for _, sorted := range []bool{false, true} {
+		lf := &locateFlags{Sorted: sorted, Depth: -1, Format: textFormat, Printf: `%s %P\n`}
+		lf.ScanSize = 100
+		if !lf.needsStat() {
+			t.Fatalf("%v: should require stat", lf.Printf)
+		}
+		out, _ := locateOutput(ctx, t, lf, withSizesSpec, "r", "type=f")
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		sort.Strings(lines)
+		if got, want := strings.Join(lines, ","), "10 f0,20 d0/f1"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPrintfRelativeRoots(t *testing.T) {
+	ctx := context.Background()
+	// The starting location must be relative to the current directory.
+	dir, err := os.MkdirTemp(".", "printf-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "d0"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"f0", filepath.Join("d0", "f1")} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := localfs.New()
+	pf, err := newPrintfFormat(`%P\n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range []string{
+		"./" + dir,
+		dir + "//",
+		filepath.Join(dir, "d0") + "/../",
+	} {
+		lf := &locateFlags{Depth: -1, Format: textFormat, Printf: `%P\n`}
+		lf.ScanSize = 100
+		var out, errs bytes.Buffer
+		o := newOutput(&out, &errs, textFormat, false)
+		v := visit{ctx: ctx, fs: fs, lf: lf, out: o, roots: cleanRoots(fs, []string{root}), printf: pf}
+		if err := (locateCmd{}).locateFS(ctx, fs, lf, v.visit, []string{root, "type=f"}); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		sort.Strings(lines)
+		if got, want := strings.Join(lines, ","), "d0/f1,f0"; got != want {
+			t.Errorf("%v: got %v, want %v", root, got, want)
+		}
+	}
+
+	v := visit{roots: []string{"/a/b", "/a/bc"}}
+	for _, path := range []string{"/a/b/f", "/a/bc/f"} {
+		if got, want := relativePath(v.rootFor(path), path), "f"; got != want {
+			t.Errorf("%v: got %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// progress periodically displays the progress of a search, as gathered
+// by walkStats, on the error stream. On a terminal the progress is
+// displayed as a single status line that is redrawn in place, otherwise
+// it is displayed as a log line per interval, or as an ndjson object per
+// interval for the json formats.
+type progress struct {
+	stats    *walkStats
+	out      *output
+	tty      bool
+	width    int
+	interval time.Duration
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	lastEntries int64
+	lastTime    time.Time
+}
+
+// startProgress starts displaying progress, stop must be called once
+// the search is complete. An interval of zero selects 500ms on a
+// terminal and 10s otherwise.
+func startProgress(ws *walkStats, out *output, tty bool, width int, interval time.Duration) *progress {
+	if interval <= 0 {
+		interval = 10 * time.Second
+		if tty {
+			interval = 500 * time.Millisecond
+		}
+	}
+	p := &progress{
+		stats:    ws,
+		out:      out,
+		tty:      tty,
+		width:    width,
+		interval: interval,
+		done:     make(chan struct{}),
+		lastTime: ws.start,
+	}
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+func (p *progress) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.display(now)
+		}
+	}
+}
+
+func (p *progress) display(now time.Time) {
+	r := p.record(now)
+	if !p.tty {
+		if p.out.format != textFormat {
+			p.out.logObject(r)
+			return
+		}
+		p.out.message("progress: %s", r.line())
+		return
+	}
+	line := r.line()
+	if r := []rune(line); p.width > 1 && len(r) > p.width-1 {
+		line = string(r[:p.width-1])
+	}
+	p.out.setStatus(line)
+}
+
+// progressRecord represents the progress of a search at a point in time.
+type progressRecord struct {
+	Elapsed     time.Duration `json:"-"`
+	WallTime    float64       `json:"wall_time_seconds"`
+	DirsScanned int64         `json:"dirs_scanned"`
+	Queued      int64         `json:"dirs_queued"`
+	Entries     int64         `json:"entries"`
+	EntriesRate float64       `json:"entries_per_second"`
+	Matches     int64         `json:"matches"`
+	Errors      int64         `json:"errors"`
+	Deepest     string        `json:"deepest_path"`
+}
+
+func (r progressRecord) line() string {
+	return fmt.Sprintf("%v: dirs %v (queued %v), entries %v (%.0f/s), matches %v, errors %v, deepest %v",
+		r.Elapsed, r.DirsScanned, r.Queued, r.Entries, r.EntriesRate, r.Matches, r.Errors, r.Deepest)
+}
+
+// record returns the current progress, the rate at which entries are
+// seen is computed over the preceding interval.
+func (p *progress) record(now time.Time) progressRecord {
+	ws := p.stats
+	entries := ws.entries.Load()
+	var rate float64
+	if elapsed := now.Sub(p.lastTime).Seconds(); elapsed > 0 {
+		rate = float64(entries-p.lastEntries) / elapsed
+	}
+	p.lastEntries, p.lastTime = entries, now
+	elapsed := now.Sub(ws.start).Round(time.Second)
+	return progressRecord{
+		Elapsed:     elapsed,
+		WallTime:    elapsed.Seconds(),
+		DirsScanned: ws.dirsScanned.Load(),
+		Queued:      ws.queued.Load(),
+		Entries:     entries,
+		EntriesRate: rate,
+		Matches:     ws.matches.Load(),
+		Errors:      ws.errorCount.Load(),
+		Deepest:     ws.deepestPath(),
+	}
+}
+
+// stop stops displaying progress and clears the status line, if any.
+func (p *progress) stop() {
+	close(p.done)
+	p.wg.Wait()
+	p.out.endStatus()
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusLine(t *testing.T) {
+	var out, errs bytes.Buffer
+	o := newOutput(&out, &errs, textFormat, false)
+	o.setStatus("working")
+	o.text("r/a")
+	o.setStatus("still working")
+	o.error("r/b", fmt.Errorf("oops"))
+	o.endStatus()
+	o.endStatus()
+	if got, want := out.String(), "r/a\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := errs.String(), "\rworking\x1b[K\r\x1b[K\rstill working\x1b[K\r\x1b[Kr/b: oops\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	ws := newWalkStats(nil)
+	ws.scanned("r")
+	ws.scanned("r/a/b")
+	ws.scanned("r/c")
+	ws.queue(3)
+	ws.queue(-1)
+	ws.entries.Add(100)
+	ws.matched(nil)
+	ws.error("other")
+
+	p := &progress{stats: ws, lastTime: ws.start}
+	line := p.record(ws.start.Add(2 * time.Second)).line()
+	if got, want := line, "2s: dirs 3 (queued 2), entries 100 (50/s), matches 1, errors 1, deepest r/a/b"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The json formats display progress as ndjson when not on a terminal.
+	var out, errs bytes.Buffer
+	p = startProgress(ws, newOutput(&out, &errs, ndjsonFormat, false), false, 20, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	p.stop()
+	first, _, _ := strings.Cut(errs.String(), "\n")
+	var r progressRecord
+	if err := json.Unmarshal([]byte(first), &r); err != nil {
+		t.Fatalf("%q: %v", first, err)
+	}
+	if got, want := r.DirsScanned, int64(3); got != want || out.Len() != 0 {
+		t.Errorf("got %v, want %v: %q", got, want, out.String())
+	}
+
+	for _, tty := range []bool{false, true} {
+		var out, errs bytes.Buffer
+		o := newOutput(&out, &errs, textFormat, false)
+		p := startProgress(ws, o, tty, 20, time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		p.stop()
+		if out.Len() != 0 {
+			t.Errorf("tty %v: unexpected output: %q", tty, out.String())
+		}
+		if !tty {
+			if !strings.HasPrefix(errs.String(), "progress: ") {
+				t.Errorf("got %q", errs.String())
+			}
+			continue
+		}
+		// The status line is truncated to the terminal width and
+		// cleared once progress is stopped.
+		if !strings.HasPrefix(errs.String(), "\r0s: dirs 3 (queued \x1b[K") {
+			t.Errorf("got %q", errs.String())
+		}
+		if !strings.HasSuffix(errs.String(), "\x1b[K\r\x1b[K") {
+			t.Errorf("got %q", errs.String())
+		}
+	}
+}
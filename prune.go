@@ -0,0 +1,90 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"cloudeng.io/cmdutil/boolexpr"
+)
+
+// PruneIfc must be implemented by any values that are used with the
+// prune operand.
+type PruneIfc interface {
+	// Prune returns the result that the prune operand evaluates to.
+	Prune() bool
+}
+
+// pruneValue is implemented by the values that the expression is
+// evaluated against to allow for the result of the prune operand to be
+// specified.
+type pruneValue interface {
+	withPrune(bool) any
+}
+
+const pruneDoc = ` stops descent into the matching directory, as per find's -prune. It always evaluates to true, but entries for which it determines the result of the expression are not themselves reported, eg. ((name=node_modules || name=.git) && prune) || name=*.ts`
+
+// pruneOperand is evaluated twice, once as false and once as true, in
+// order to determine if it was reached and determined the result of
+// the expression. This is required since operands have no access to
+// the expression they are part of and cannot rely on being evaluated
+// in a particular order.
+type pruneOperand struct {
+	name, text string
+}
+
+func (op pruneOperand) Prepare() (boolexpr.Operand, error) {
+	if op.text != "true" {
+		return op, fmt.Errorf("%v: does not accept a value: %q", op.name, op.text)
+	}
+	return op, nil
+}
+
+func (op pruneOperand) Eval(v any) bool {
+	if p, ok := v.(PruneIfc); ok {
+		return p.Prune()
+	}
+	return false
+}
+
+func (op pruneOperand) Needs(t reflect.Type) bool {
+	return t.Implements(reflect.TypeOf((*PruneIfc)(nil)).Elem())
+}
+
+func (op pruneOperand) Document() string {
+	return op.name + pruneDoc
+}
+
+func (op pruneOperand) String() string {
+	return op.name
+}
+
+// registerPruneOperand registers the prune operand and returns a
+// function that reports the number of such operands created.
+func registerPruneOperand(parser *boolexpr.Parser) func() int {
+	var n int
+	parser.RegisterOperand("prune", func(name, v string) boolexpr.Operand {
+		n++
+		return pruneOperand{name: name, text: v}
+	})
+	return func() int { return n }
+}
+
+// evalPrune evaluates the expression against v and determines whether
+// v is to be pruned, ie. whether the expression is true when the prune
+// operand is true, but false otherwise. Pruned entries are not reported
+// as matches.
+func (e expression) evalPrune(v any) (matched, pruned bool) {
+	p, ok := v.(pruneValue)
+	if e.pruneOperands == 0 || !ok {
+		return e.Eval(v), false
+	}
+	without := e.Eval(p.withPrune(false))
+	if without {
+		return true, false
+	}
+	return false, e.Eval(p.withPrune(true))
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPruneOperand(t *testing.T) {
+	nm := entryType{name: "node_modules", mode: fs.ModeDir}
+	ts := entryType{name: "a.ts"}
+	js := entryType{name: "a.js"}
+	for _, tc := range []struct {
+		expr                  string
+		matched, pruned       bool
+		val                   entryType
+		needsStat, needsPrune bool
+	}{
+		{"(name=node_modules && prune) || name=*.ts", false, true, nm, false, true},
+		{"(name=node_modules && prune) || name=*.ts", true, false, ts, false, true},
+		{"(name=node_modules && prune) || name=*.ts", false, false, js, false, true},
+		{"name=node_modules || prune", true, false, nm, false, true},
+		{"name=*.ts || prune", false, true, js, false, true},
+		{"name=*.ts", true, false, ts, false, false},
+	} {
+		expr := newExpr(t, tc.expr)
+		matched, pruned := expr.evalPrune(tc.val)
+		if got, want := matched, tc.matched; got != want {
+			t.Errorf("%v: %v: matched: got %v, want %v", tc.expr, tc.val.name, got, want)
+		}
+		if got, want := pruned, tc.pruned; got != want {
+			t.Errorf("%v: %v: pruned: got %v, want %v", tc.expr, tc.val.name, got, want)
+		}
+		if got, want := expr.NeedsStat(), tc.needsStat; got != want {
+			t.Errorf("%v: needs stat: got %v, want %v", tc.expr, got, want)
+		}
+		if got, want := expr.pruneOperands > 0, tc.needsPrune; got != want {
+			t.Errorf("%v: prune operands: got %v, want %v", tc.expr, got, want)
+		}
+	}
+
+	// && and || have the same precedence and are evaluated left to right.
+	if expr := "name=a.ts || name=x && type=d"; newExpr(t, expr).Eval(ts) {
+		t.Errorf("%v: should be evaluated as (name=a.ts || name=x) && type=d", expr)
+	}
+
+	if _, err := createExpr([]string{"prune=false"}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+const pruneSpec = `
+name: r
+entries:
+  - dir:
+	  name: node_modules
+	  entries:
+		- file:
+			name: a.ts
+		- dir:
+			name: m
+			entries:
+			  - file:
+				  name: b.ts
+  - dir:
+	  name: .git
+	  entries:
+		- file:
+			name: c.ts
+  - dir:
+	  name: src
+	  entries:
+		- file:
+			name: d.ts
+		- file:
+			name: e.js
+		- dir:
+			name: node_modules
+			entries:
+			  - file:
+				  name: f.ts
+  - file:
+	  name: g.ts
+`
+
+func TestPruneWalk(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		expr     string
+		minDepth int
+		output   string
+	}{
+		{"((name=node_modules || name=.git) && prune) || name=*.ts", 0, "r/g.ts,r/src/d.ts"},
+		{"(name=node_modules && prune) || name=*.ts", 0, "r/.git/c.ts,r/g.ts,r/src/d.ts"},
+		{"(name=node_modules && prune) || name=*.ts", 2, "r/.git/c.ts,r/src/d.ts"},
+		{"(name=src && prune) || name=*.ts", 0, "r/.git/c.ts,r/g.ts,r/node_modules/a.ts,r/node_modules/m/b.ts"},
+		// src is not pruned since the expression is true regardless.
+		{"(name=src && prune) || type=d", 0, "r,r/.git,r/node_modules,r/node_modules/m,r/src,r/src/node_modules"},
+		{"name=src && prune", 0, ""},
+		{"(name=r && prune) || name=*.ts", 0, ""},
+		// size requires stat and hence the withStat code paths.
+		{"(name=node_modules && prune) || size>=0", 0, "r/.git/c.ts,r/g.ts,r/src/d.ts,r/src/e.js"},
+	} {
+		for _, sorted := range []bool{false, true} {
+			lf := &locateFlags{Sorted: sorted, Depth: -1, MinDepth: tc.minDepth, Format: textFormat}
+			lf.ScanSize = 2
+			out, errs := locateOutput(ctx, t, lf, pruneSpec, "r", tc.expr)
+			if len(errs) > 0 {
+				t.Errorf("%v: unexpected errors: %v", tc.expr, errs)
+			}
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			sort.Strings(lines)
+			if got, want := strings.Join(lines, ","), tc.output; got != want {
+				t.Errorf("%v: sorted %v: got %v, want %v", tc.expr, sorted, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cloudeng.io/aws/awsconfig"
+	"cloudeng.io/aws/s3fs"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/localfs"
+	"cloudeng.io/path/cloudpath"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// splitLocateArgs splits the arguments to the locate command into the
+// starting locations and the expression. Multiple starting locations
+// must be separated from the expression by --, otherwise the first
+// argument is the only starting location, unless fromFile is set in
+// which case all of the arguments are the expression.
+func splitLocateArgs(args []string, fromFile bool) (roots, expr []string) {
+	for i, a := range args {
+		if a == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	if fromFile || len(args) == 0 {
+		return nil, args
+	}
+	return args[:1], args[1:]
+}
+
+// readRoots reads starting locations from rd, one per line or, if null
+// is set, separated by NUL characters. Empty entries are ignored.
+func readRoots(rd io.Reader, null bool) ([]string, error) {
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	if null {
+		sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := bytes.IndexByte(data, 0); i >= 0 {
+				return i + 1, data[:i], nil
+			}
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		})
+	}
+	var roots []string
+	for sc.Scan() {
+		root := sc.Text()
+		if !null {
+			root = strings.TrimRight(root, "\r")
+		}
+		if len(root) > 0 {
+			roots = append(roots, root)
+		}
+	}
+	return roots, sc.Err()
+}
+
+// readRootsFrom reads starting locations from the named file, or stdin
+// if the name is -.
+func readRootsFrom(name string, null bool) ([]string, error) {
+	if name == "-" {
+		return readRoots(os.Stdin, null)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readRoots(f, null)
+}
+
+// uniqueRoots removes duplicate starting locations and those that are
+// contained within another starting location, the order of those that
+// remain is preserved. The locations are compared using their absolute
+// paths for the local file system.
+func uniqueRoots(wkfs filewalk.FS, roots []string) []string {
+	if len(roots) < 2 {
+		return roots
+	}
+	sep, canonical := "/", func(p string) string { return p }
+	if wkfs.Scheme() == "file" {
+		sep = string(filepath.Separator)
+		canonical = func(p string) string {
+			if abs, err := filepath.Abs(p); err == nil {
+				return abs
+			}
+			return filepath.Clean(p)
+		}
+	}
+	keys := make([]string, len(roots))
+	order := make([]int, len(roots))
+	for i, r := range roots {
+		keys[i] = canonical(r)
+		order[i] = i
+	}
+	// Consider shorter paths first since they may contain longer ones.
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(keys[order[i]]) < len(keys[order[j]])
+	})
+	keep := make([]bool, len(roots))
+	var kept []string
+	for _, i := range order {
+		contained := false
+		for _, k := range kept {
+			prefix := k
+			if !strings.HasSuffix(prefix, sep) {
+				prefix += sep
+			}
+			if keys[i] == k || strings.HasPrefix(keys[i], prefix) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			keep[i] = true
+			kept = append(kept, keys[i])
+		}
+	}
+	unique := make([]string, 0, len(kept))
+	for i, r := range roots {
+		if keep[i] {
+			unique = append(unique, r)
+		}
+	}
+	return unique
+}
+
+// cleanRoots returns the starting locations for the local file system
+// cleaned, as are the paths created by joining them with the names of
+// the files and directories found below them, other starting locations
+// are unchanged.
+func cleanRoots(wkfs filewalk.FS, roots []string) []string {
+	if wkfs.Scheme() != "file" {
+		return roots
+	}
+	cleaned := make([]string, len(roots))
+	for i, r := range roots {
+		cleaned[i] = filepath.Clean(r)
+	}
+	return cleaned
+}
+
+// hasPathPrefix returns true if path is prefix or is below it.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	if len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || strings.HasSuffix(prefix, "\\") {
+		return true
+	}
+	return path[len(prefix)] == '/' || path[len(prefix)] == '\\'
+}
+
+// absoluteRoots returns the absolute paths of the starting locations
+// for the local file system, other starting locations are unchanged.
+func absoluteRoots(wkfs filewalk.FS, roots []string) []string {
+	if wkfs.Scheme() != "file" {
+		return roots
+	}
+	abs := make([]string, len(roots))
+	for i, r := range roots {
+		if a, err := filepath.Abs(r); err == nil {
+			r = a
+		}
+		abs[i] = r
+	}
+	return abs
+}
+
+// rootGroup represents the starting locations that share a file system.
+type rootGroup struct {
+	scheme string
+	fs     filewalk.FS
+	roots  []string
+}
+
+// fileSystems creates a filewalk.FS for each of the schemes used
+// by a set of starting locations.
+type fileSystems struct {
+	cfg    aws.Config
+	hasCfg bool
+}
+
+func (f *fileSystems) awsConfig(ctx context.Context) (aws.Config, error) {
+	if f.hasCfg {
+		return f.cfg, nil
+	}
+	cfg, err := awsconfig.Load(ctx)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	f.cfg, f.hasCfg = cfg, true
+	return cfg, nil
+}
+
+// group resolves each starting location to its file system and groups
+// them by file system, in order of first use.
+func (f *fileSystems) group(ctx context.Context, roots []string) ([]*rootGroup, error) {
+	var groups []*rootGroup
+	byScheme := map[string]*rootGroup{}
+	for _, root := range roots {
+		match := cloudpath.DefaultMatchers.Match(root)
+		if len(match.Matched) == 0 {
+			return nil, fmt.Errorf("unsupported path: %v", root)
+		}
+		if g, ok := byScheme[match.Scheme]; ok {
+			g.roots = append(g.roots, root)
+			continue
+		}
+		g := &rootGroup{scheme: match.Scheme, roots: []string{root}}
+		switch match.Scheme {
+		case "s3":
+			cfg, err := f.awsConfig(ctx)
+			if err != nil {
+				return nil, err
+			}
+			g.fs = s3fs.New(cfg)
+		case "unix":
+			g.fs = localfs.New()
+		default:
+			return nil, fmt.Errorf("unsupported file system scheme: %v", match.Scheme)
+		}
+		byScheme[match.Scheme] = g
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
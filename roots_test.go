@@ -0,0 +1,123 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/filewalktestutil"
+	"cloudeng.io/file/localfs"
+)
+
+func TestSplitLocateArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args        []string
+		fromFile    bool
+		roots, expr []string
+	}{
+		{nil, false, nil, nil},
+		{[]string{"a"}, false, []string{"a"}, []string{}},
+		{[]string{"a", "name=x"}, false, []string{"a"}, []string{"name=x"}},
+		{[]string{"a", "b", "--", "name=x", "||", "name=y"}, false, []string{"a", "b"}, []string{"name=x", "||", "name=y"}},
+		{[]string{"a", "--"}, false, []string{"a"}, []string{}},
+		{[]string{"name=x"}, true, nil, []string{"name=x"}},
+		{[]string{"a", "--", "name=x"}, true, []string{"a"}, []string{"name=x"}},
+	} {
+		roots, expr := splitLocateArgs(tc.args, tc.fromFile)
+		if got, want := roots, tc.roots; len(got) != len(want) || (len(got) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("%v: got %v, want %v", tc.args, got, want)
+		}
+		if got, want := expr, tc.expr; len(got) != len(want) || (len(got) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("%v: got %v, want %v", tc.args, got, want)
+		}
+	}
+}
+
+func TestReadRoots(t *testing.T) {
+	roots, err := readRoots(strings.NewReader("a\n\nb c\r\ns3://bucket/d\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := roots, []string{"a", "b c", "s3://bucket/d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	roots, err = readRoots(strings.NewReader("a\x00b\nc\x00\x00d"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := roots, []string{"a", "b\nc", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestUniqueRoots(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockfs, err := filewalktestutil.NewMockFS("r", filewalktestutil.WithYAMLConfig(withSizesSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := localfs.New()
+	for _, tc := range []struct {
+		local  bool
+		roots  []string
+		unique []string
+	}{
+		{true, []string{"a"}, []string{"a"}},
+		{true, []string{"a", "b", "a"}, []string{"a", "b"}},
+		{true, []string{"a/b", "a", "ab"}, []string{"a", "ab"}},
+		{true, []string{"a/b", "./a/", filepath.Join(cwd, "a", "c")}, []string{"./a/"}},
+		{true, []string{"/", "/a", "b"}, []string{"/"}},
+		{false, []string{"s3://b/x/y", "s3://b/x", "s3://b/xy", "s3://c/x"}, []string{"s3://b/x", "s3://b/xy", "s3://c/x"}},
+	} {
+		var fs filewalk.FS = mockfs
+		if tc.local {
+			fs = local
+		}
+		if got, want := uniqueRoots(fs, tc.roots), tc.unique; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", tc.roots, got, want)
+		}
+	}
+}
+
+func TestMultipleRoots(t *testing.T) {
+	ctx := context.Background()
+	a0 := filepath.Join(localTestTree, "a0")
+	b0 := filepath.Join(localTestTree, "b0")
+	a00 := filepath.Join(localTestTree, "a0", "a0.0")
+	files := zipf(zips(
+		"/a0", "/a0", "/a0", "/a0",
+		"/a0/a0.0", "/a0/a0.0", "/a0/a0.0",
+		"/a0/a0.1", "/a0/a0.1", "/a0/a0.1",
+		"/b0/b0.0", "/b0/b0.0", "/b0/b0.0",
+		"/b0/b0.1/b1.0", "/b0/b0.1/b1.0", "/b0/b0.1/b1.0"),
+		"f0", "f1", "f2", "inaccessible-file",
+		"f0", "f1", "f2",
+		"f0", "f1", "f2",
+		"f0", "f1", "f2",
+		"f0", "f1", "f2")
+	sortFound(files)
+	dirs := zipf(zips("/a0", "/a0", "/a0", "/b0", "/b0", "/b0/b0.1", "/a0", "/b0"),
+		"a0.0", "a0.1", "inaccessible-dir", "b0.0", "b0.1", "b1.0", "", "")
+	sortFound(dirs)
+	for _, sorted := range []bool{false, true} {
+		lf := &locateFlags{Sorted: sorted, Depth: -1}
+		lf.ScanSize = 1
+		found, errs := locate(ctx, t, lf, a0, b0, a00, a0, "--", "type=f")
+		cmpFound(t, found, files)
+		cmpFound(t, errs, zipf(zips("/a0/inaccessible-dir"), ""))
+
+		found, _ = locate(ctx, t, lf, a0, b0, "--", "type=d")
+		cmpFound(t, found, dirs)
+	}
+}
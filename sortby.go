@@ -0,0 +1,442 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/filewalk"
+)
+
+// sortKey is one of the keys specified by --sort-by. Numeric keys are
+// computed when a match is added, path and name are compared directly.
+type sortKey struct {
+	name    string
+	reverse bool
+	num     func(v visit, path string, fi *file.Info) int64
+}
+
+var sortKeys = map[string]func(v visit, path string, fi *file.Info) int64{
+	"path": nil,
+	"name": nil,
+	"depth": func(v visit, path string, _ *file.Info) int64 {
+		rel := relativePath(v.rootFor(path), path)
+		return int64(len(strings.FieldsFunc(rel, func(r rune) bool {
+			return r == '/' || r == '\\'
+		})))
+	},
+	"size": func(_ visit, _ string, fi *file.Info) int64 {
+		return fi.Size()
+	},
+	"mtime": func(_ visit, _ string, fi *file.Info) int64 {
+		return fi.ModTime().UnixNano()
+	},
+	"atime": func(_ visit, _ string, fi *file.Info) int64 {
+		t, _ := accessTimeFromSys(fi.Sys())
+		return t.UnixNano()
+	},
+}
+
+// parseSortKeys parses the value of --sort-by, a comma separated list
+// of keys each of which may be preceded by - to reverse the default,
+// ascending, order.
+func parseSortKeys(spec string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, k := range strings.Split(spec, ",") {
+		k = strings.TrimSpace(k)
+		name := strings.TrimPrefix(k, "-")
+		num, ok := sortKeys[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported --sort-by key: %q, use a comma separated list of path, name, depth, size, mtime or atime, each optionally preceded by -", k)
+		}
+		keys = append(keys, sortKey{name: name, reverse: len(name) != len(k), num: num})
+	}
+	return keys, nil
+}
+
+// sortedMatch is a match retained by matchSorter, nums holds the values
+// of the numeric keys, in the order that they were specified.
+type sortedMatch struct {
+	v                  visit
+	path, parent, name string
+	entry              filewalk.Entry
+	fi                 *file.Info
+	nums               []int64
+}
+
+// matchSorter sorts all of the matches according to the keys specified
+// by --sort-by. Up to maxBuffered matches are sorted in memory, larger
+// result sets are written to temporary files as sorted runs that are
+// merged once the search is complete, ie. an external merge sort.
+type matchSorter struct {
+	mu          sync.Mutex
+	keys        []sortKey
+	maxBuffered int
+	buffered    []sortedMatch
+	// visits records the visit used for each group of starting locations
+	// so that it can be restored for matches read from a run.
+	visits map[int]visit
+	runs   []*os.File
+	err    error
+}
+
+func newMatchSorter(spec string, maxBuffered int) (*matchSorter, error) {
+	keys, err := parseSortKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &matchSorter{
+		keys:        keys,
+		maxBuffered: max(maxBuffered, 1),
+		visits:      map[int]visit{},
+	}, nil
+}
+
+// compare compares two matches according to the sort keys, ties are
+// broken using the path so that the results are deterministic.
+func (s *matchSorter) compare(a, b sortedMatch) int {
+	for i, k := range s.keys {
+		var c int
+		switch k.name {
+		case "path":
+			c = strings.Compare(a.path, b.path)
+		case "name":
+			c = strings.Compare(a.name, b.name)
+		default:
+			c = cmp.Compare(a.nums[i], b.nums[i])
+		}
+		if k.reverse {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.path, b.path)
+}
+
+func (s *matchSorter) add(v visit, path, parent, name string, entry filewalk.Entry, fi *file.Info) {
+	m := sortedMatch{v: v, path: path, parent: parent, name: name, entry: entry, fi: fi}
+	m.nums = make([]int64, len(s.keys))
+	for i, k := range s.keys {
+		if k.num != nil && fi != nil {
+			m.nums[i] = k.num(v, path, fi)
+		}
+	}
+	s.mu.Lock()
+	if s.err != nil {
+		// The matches can no longer be sorted and hence there is
+		// no point in retaining them.
+		s.mu.Unlock()
+		return
+	}
+	if _, ok := s.visits[v.group]; !ok {
+		s.visits[v.group] = v
+	}
+	s.buffered = append(s.buffered, m)
+	if len(s.buffered) < s.maxBuffered {
+		s.mu.Unlock()
+		return
+	}
+	// Spill the full buffer without holding the lock so that other
+	// matches may continue to be buffered in the meantime.
+	full := s.buffered
+	s.buffered = make([]sortedMatch, 0, s.maxBuffered)
+	s.mu.Unlock()
+	f, err := s.spill(full)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addRun(f, err)
+}
+
+// addRun records a run written by spill, along with any error
+// encountered in writing it.
+func (s *matchSorter) addRun(f *os.File, err error) {
+	if f != nil {
+		s.runs = append(s.runs, f)
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+		s.buffered = nil
+	}
+}
+
+// spill writes the supplied matches, sorted, to a temporary file. The
+// file is returned, so that it can be removed, even if an error is
+// encountered in writing it.
+func (s *matchSorter) spill(matches []sortedMatch) (*os.File, error) {
+	slices.SortStableFunc(matches, s.compare)
+	f, err := os.CreateTemp("", "ufind-sort-")
+	if err != nil {
+		return nil, err
+	}
+	wr := bufio.NewWriter(f)
+	// bufio.Writer errors are sticky and hence returned by Flush.
+	var buf bytes.Buffer
+	for i := range matches {
+		buf.Reset()
+		if err := s.encode(&buf, &matches[i]); err != nil {
+			return f, err
+		}
+		var hdr [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(hdr[:], uint64(buf.Len()))
+		wr.Write(hdr[:n])
+		wr.Write(buf.Bytes())
+	}
+	if err := wr.Flush(); err != nil {
+		return f, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return f, err
+}
+
+// finish calls fn for every match in sorted order and removes any
+// temporary files.
+func (s *matchSorter) finish(fn func(m sortedMatch)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.cleanup()
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.runs) == 0 {
+		slices.SortStableFunc(s.buffered, s.compare)
+		for _, m := range s.buffered {
+			fn(m)
+		}
+		return nil
+	}
+	if len(s.buffered) > 0 {
+		s.addRun(s.spill(s.buffered))
+		if s.err != nil {
+			return s.err
+		}
+	}
+	return s.merge(fn)
+}
+
+func (s *matchSorter) cleanup() {
+	for _, f := range s.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	s.runs = nil
+	s.buffered = nil
+}
+
+// run is a sorted run read back from a temporary file.
+type run struct {
+	rd   *bufio.Reader
+	next sortedMatch
+	buf  []byte
+}
+
+// read reads the next match from the run, returning false once the
+// run is exhausted.
+func (r *run) read(s *matchSorter) (bool, error) {
+	l, err := binary.ReadUvarint(r.rd)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if cap(r.buf) < int(l) {
+		r.buf = make([]byte, l)
+	}
+	r.buf = r.buf[:l]
+	if _, err := io.ReadFull(r.rd, r.buf); err != nil {
+		return false, err
+	}
+	r.next, err = s.decode(r.buf)
+	return err == nil, err
+}
+
+type runHeap struct {
+	s    *matchSorter
+	runs []*run
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool {
+	return h.s.compare(h.runs[i].next, h.runs[j].next) < 0
+}
+func (h *runHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x any)    { h.runs = append(h.runs, x.(*run)) }
+func (h *runHeap) Pop() any {
+	n := len(h.runs)
+	r := h.runs[n-1]
+	h.runs = h.runs[:n-1]
+	return r
+}
+
+// merge merges the sorted runs.
+func (s *matchSorter) merge(fn func(m sortedMatch)) error {
+	h := &runHeap{s: s}
+	for _, f := range s.runs {
+		r := &run{rd: bufio.NewReader(f)}
+		ok, err := r.read(s)
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		r := h.runs[0]
+		fn(r.next)
+		ok, err := r.read(s)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+			continue
+		}
+		heap.Pop(h)
+	}
+	return nil
+}
+
+func appendSortString(buf *bytes.Buffer, s string) {
+	buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	buf.WriteString(s)
+}
+
+// encode encodes a match, including the extended attributes of its
+// file.Info, since the system specific information is not encoded by
+// file.Info itself.
+func (s *matchSorter) encode(buf *bytes.Buffer, m *sortedMatch) error {
+	buf.Write(binary.AppendVarint(nil, int64(m.v.group)))
+	appendSortString(buf, m.path)
+	appendSortString(buf, m.parent)
+	appendSortString(buf, m.name)
+	appendSortString(buf, m.entry.Name)
+	buf.Write(binary.AppendUvarint(nil, uint64(m.entry.Type)))
+	for _, n := range m.nums {
+		buf.Write(binary.AppendVarint(nil, n))
+	}
+	if m.fi == nil {
+		buf.WriteByte(0)
+		return nil
+	}
+	buf.WriteByte(1)
+	if err := m.fi.AppendBinary(buf); err != nil {
+		return err
+	}
+	xattr := m.v.xattr(m.path, m.fi)
+	var data []byte
+	data = binary.AppendVarint(data, xattr.UID)
+	data = binary.AppendVarint(data, xattr.GID)
+	data = binary.AppendUvarint(data, xattr.Device)
+	data = binary.AppendUvarint(data, xattr.FileID)
+	data = binary.AppendVarint(data, xattr.Blocks)
+	data = binary.AppendUvarint(data, xattr.Hardlinks)
+	buf.Write(data)
+	appendSortString(buf, xattr.User)
+	appendSortString(buf, xattr.Group)
+	return nil
+}
+
+// recordDecoder decodes the varint and string fields of a record,
+// recording the first error encountered, which is corrupt for any
+// record that is truncated or otherwise invalid.
+type recordDecoder struct {
+	data    []byte
+	err     error
+	corrupt error
+}
+
+var errCorruptRun = errors.New("corrupt sort run")
+
+func (d *recordDecoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = d.corrupt
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *recordDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = d.corrupt
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *recordDecoder) string() string {
+	l := d.uvarint()
+	if d.err != nil || uint64(len(d.data)) < l {
+		d.err = d.corrupt
+		return ""
+	}
+	s := string(d.data[:l])
+	d.data = d.data[l:]
+	return s
+}
+
+func (s *matchSorter) decode(data []byte) (sortedMatch, error) {
+	d := &recordDecoder{data: data, corrupt: errCorruptRun}
+	var m sortedMatch
+	m.v = s.visits[int(d.varint())]
+	m.path = d.string()
+	m.parent = d.string()
+	m.name = d.string()
+	m.entry.Name = d.string()
+	m.entry.Type = fs.FileMode(d.uvarint())
+	m.nums = make([]int64, len(s.keys))
+	for i := range m.nums {
+		m.nums[i] = d.varint()
+	}
+	if d.err != nil || len(d.data) == 0 {
+		return m, errCorruptRun
+	}
+	hasInfo := d.data[0] == 1
+	d.data = d.data[1:]
+	if !hasInfo {
+		return m, nil
+	}
+	var fi file.Info
+	rest, err := fi.DecodeBinary(d.data)
+	if err != nil {
+		return m, err
+	}
+	d.data = rest
+	var xattr file.XAttr
+	xattr.UID = d.varint()
+	xattr.GID = d.varint()
+	xattr.Device = d.uvarint()
+	xattr.FileID = d.uvarint()
+	xattr.Blocks = d.varint()
+	xattr.Hardlinks = d.uvarint()
+	xattr.User = d.string()
+	xattr.Group = d.string()
+	if d.err != nil {
+		return m, d.err
+	}
+	fi.SetSys(m.v.fs.SysXAttr(nil, xattr))
+	m.fi = &fi
+	return m, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloudeng.io/file/filewalk"
+)
+
+func TestSortBy(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		sortBy string
+		expr   string
+		output string
+	}{
+		{"size", "type=f", "r/f0,r/d0/f3,r/d0/f4,r/d0/f2,r/f1"},
+		{"-size,path", "type=f", "r/f1,r/d0/f2,r/d0/f3,r/d0/f4,r/f0"},
+		{"-size,-path", "type=f", "r/f1,r/d0/f2,r/d0/f4,r/d0/f3,r/f0"},
+		{"mtime", "type=f", "r/f1,r/d0/f2,r/f0,r/d0/f3,r/d0/f4"},
+		{"name", "type=f", "r/f0,r/f1,r/d0/f2,r/d0/f3,r/d0/f4"},
+		{"depth,name", "", "r,r/d0,r/f0,r/f1,r/d0/f2,r/d0/f3,r/d0/f4"},
+		{"-depth, -name", "type=f", "r/d0/f4,r/d0/f3,r/d0/f2,r/f1,r/f0"},
+		{"path", "", "r,r/d0,r/d0/f2,r/d0/f3,r/d0/f4,r/f0,r/f1"},
+	} {
+		// A buffer of 1 or 2 matches forces the use of sorted runs.
+		for _, buffer := range []int{1, 2, 100} {
+			for _, sorted := range []bool{false, true} {
+				lf := &locateFlags{Sorted: sorted, Depth: -1, Format: textFormat, SortBy: tc.sortBy, SortBuffer: buffer}
+				lf.ScanSize = 1
+				args := []string{"r"}
+				if len(tc.expr) > 0 {
+					args = append(args, tc.expr)
+				}
+				out, errs := locateOutput(ctx, t, lf, topSpec, args...)
+				if len(errs) > 0 {
+					t.Errorf("unexpected errors: %v", errs)
+				}
+				lines := strings.Split(strings.TrimSpace(out), "\n")
+				if got, want := strings.Join(lines, ","), tc.output; got != want {
+					t.Errorf("%v %v: buffer %v, sorted %v: got %v, want %v", tc.sortBy, tc.expr, buffer, sorted, got, want)
+				}
+			}
+		}
+	}
+
+	for _, lf := range []*locateFlags{
+		{SortBy: "color", Format: textFormat},
+		{SortBy: "size,", Format: textFormat},
+		{SortBy: "size", Top: 1, By: "size", Format: textFormat},
+		{SortBy: "size", Limit: 1, Format: textFormat},
+		{SortBy: "size", Delete: true, Format: textFormat},
+	} {
+		if err := lf.validate(); err == nil {
+			t.Errorf("%+v: expected an error", lf)
+		}
+	}
+}
+
+func TestSortByOutput(t *testing.T) {
+	ctx := context.Background()
+	// Matches read back from sorted runs must be displayed in the same
+	// way as those sorted in memory, including their extended attributes.
+	for _, lf := range []locateFlags{
+		{Format: "ndjson"},
+		{Format: textFormat, Long: true},
+		{Format: textFormat, Printf: `%s %U %G %i %D %y %p\n`},
+	} {
+		var expected string
+		for _, buffer := range []int{100, 1} {
+			lf := lf
+			lf.Depth, lf.SortBy, lf.SortBuffer = -1, "-size", buffer
+			lf.ScanSize = 1
+			out, errs := locateOutput(ctx, t, &lf, withDeviceSpec, "r")
+			if len(errs) > 0 {
+				t.Errorf("unexpected errors: %v", errs)
+			}
+			if buffer == 100 {
+				expected = out
+				continue
+			}
+			if got, want := out, expected; got != want {
+				t.Errorf("%+v: got %v, want %v", lf, got, want)
+			}
+		}
+	}
+}
+
+func TestSortBySpillError(t *testing.T) {
+	// Runs cannot be created in a non-existent temporary directory.
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	s, err := newMatchSorter("path", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"c", "b", "a", "d", "e"} {
+		s.add(visit{}, p, "", p, filewalk.Entry{Name: p}, nil)
+	}
+	// Matches are no longer buffered once an error is encountered.
+	if got, want := len(s.buffered), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := s.finish(func(sortedMatch) { t.Errorf("unexpected match") }); err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+
+	"cloudeng.io/cmdutil/boolexpr"
+	"cloudeng.io/file/matcher"
+)
+
+// timeNow is used to determine the current time when preparing
+// operands that refer to the age of a file, it may be overridden
+// for testing.
+var timeNow = time.Now
+
+// AccessTimeIfc must be implemented by any values that are used with
+// operands that refer to the access time of a file.
+type AccessTimeIfc interface {
+	AccessTime() time.Time
+}
+
+// ChangeTimeIfc must be implemented by any values that are used with
+// operands that refer to the change time of a file.
+type ChangeTimeIfc interface {
+	ChangeTime() time.Time
+}
+
+type timeField int
+
+const (
+	modTime timeField = iota
+	accessTime
+	changeTime
+)
+
+func (tf timeField) requires() reflect.Type {
+	switch tf {
+	case accessTime:
+		return reflect.TypeOf((*AccessTimeIfc)(nil)).Elem()
+	case changeTime:
+		return reflect.TypeOf((*ChangeTimeIfc)(nil)).Elem()
+	}
+	return reflect.TypeOf((*matcher.ModTimeIfc)(nil)).Elem()
+}
+
+func (tf timeField) value(v any) (time.Time, bool) {
+	switch tf {
+	case modTime:
+		if t, ok := v.(matcher.ModTimeIfc); ok {
+			return t.ModTime(), true
+		}
+	case accessTime:
+		if t, ok := v.(AccessTimeIfc); ok {
+			return t.AccessTime(), true
+		}
+	case changeTime:
+		if t, ok := v.(ChangeTimeIfc); ok {
+			return t.ChangeTime(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+var ageUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+var ageRE = regexp.MustCompile(`^(\d+(?:\.\d+)?)([smhdw])`)
+
+// parseAge parses an age expressed as a sequence of numbers and units,
+// such as 36h, 1.5d or 1w2d, where the units are s, m, h, d (day) and
+// w (week). The unit of the last component is also returned.
+func parseAge(text string) (time.Duration, time.Duration, error) {
+	if len(text) == 0 {
+		return 0, 0, fmt.Errorf("empty age")
+	}
+	var age, unit time.Duration
+	for rest := text; len(rest) > 0; {
+		m := ageRE.FindStringSubmatch(rest)
+		if m == nil {
+			return 0, 0, fmt.Errorf("invalid age: %q, use a number followed by one of s, m, h, d or w, eg. 36h or 1w2d", text)
+		}
+		n, _ := strconv.ParseFloat(m[1], 64)
+		unit = ageUnits[m[2]]
+		age += time.Duration(n * float64(unit))
+		rest = rest[len(m[0]):]
+	}
+	return age, unit, nil
+}
+
+// parseTime parses an absolute time using the same formats as the
+// newer operand, the granularity of the time is also returned.
+func parseTime(text string) (time.Time, time.Duration, error) {
+	if t, err := time.Parse(time.DateOnly, text); err == nil {
+		return t, 24 * time.Hour, nil
+	}
+	for _, format := range []string{time.RFC3339, time.DateTime} {
+		if t, err := time.Parse(format, text); err == nil {
+			return t, time.Second, nil
+		}
+	}
+	return time.Time{}, 0, fmt.Errorf("invalid time: %v, use one of RFC3339, Date and Time or Date only formats", text)
+}
+
+// timeOperand compares one of the times associated with a file against
+// either an age relative to the current time or an absolute time.
+type timeOperand struct {
+	name, text, document string
+	field                timeField
+	// ageOp and timeOp are the comparisons to use for ages and absolute
+	// times respectively, if empty the comparison must be specified as
+	// part of the value.
+	ageOp, timeOp string
+	match         func(time.Time) bool
+}
+
+func newTimeOperand(name, value string, field timeField, ageOp, timeOp, document string) boolexpr.Operand {
+	return timeOperand{
+		name:     name,
+		text:     value,
+		field:    field,
+		ageOp:    ageOp,
+		timeOp:   timeOp,
+		document: name + document,
+	}
+}
+
+func (op timeOperand) Prepare() (boolexpr.Operand, error) {
+	cmp, value := "", op.text
+	if len(op.ageOp) == 0 {
+		cmp, value = splitComparison(op.text)
+		if len(cmp) == 0 {
+			cmp = "="
+		}
+	}
+	age, unit, err := parseAge(value)
+	if err == nil {
+		if len(op.ageOp) > 0 {
+			cmp = op.ageOp
+		}
+		op.match = matchAge(cmp, timeNow(), age, unit)
+		return op, nil
+	}
+	if len(op.ageOp) > 0 && len(op.timeOp) == 0 {
+		return op, fmt.Errorf("%v: %v", op.name, err)
+	}
+	when, granularity, err := parseTime(value)
+	if err != nil {
+		return op, fmt.Errorf("%v: %q is neither an age nor a time: %v", op.name, value, err)
+	}
+	if len(op.timeOp) > 0 {
+		cmp = op.timeOp
+	}
+	op.match = matchTime(cmp, when, granularity)
+	return op, nil
+}
+
+// matchAge returns a function that compares the age of a time,
+// relative to now, with age. For equality the age is compared at
+// the granularity of its unit, eg. 2d matches any time that is
+// between 2 and 3 days old.
+func matchAge(cmp string, now time.Time, age, unit time.Duration) func(time.Time) bool {
+	then := now.Add(-age)
+	switch cmp {
+	case "<":
+		return func(t time.Time) bool { return t.After(then) }
+	case "<=":
+		return func(t time.Time) bool { return !t.Before(then) }
+	case ">":
+		return func(t time.Time) bool { return t.Before(then) }
+	case ">=":
+		return func(t time.Time) bool { return !t.After(then) }
+	}
+	earliest := then.Add(-unit)
+	return func(t time.Time) bool { return t.After(earliest) && !t.After(then) }
+}
+
+// matchTime returns a function that compares a time with when. For
+// equality the time is compared at the granularity of when, eg. a date
+// matches any time on that day.
+func matchTime(cmp string, when time.Time, granularity time.Duration) func(time.Time) bool {
+	switch cmp {
+	case "<":
+		return func(t time.Time) bool { return t.Before(when) }
+	case "<=":
+		return func(t time.Time) bool { return !t.After(when) }
+	case ">":
+		return func(t time.Time) bool { return t.After(when) }
+	case ">=":
+		return func(t time.Time) bool { return !t.Before(when) }
+	}
+	end := when.Add(granularity)
+	return func(t time.Time) bool { return !t.Before(when) && t.Before(end) }
+}
+
+func (op timeOperand) Eval(v any) bool {
+	t, ok := op.field.value(v)
+	if !ok || t.IsZero() {
+		return false
+	}
+	return op.match(t)
+}
+
+func (op timeOperand) Needs(t reflect.Type) bool {
+	return t.Implements(op.field.requires())
+}
+
+func (op timeOperand) Document() string {
+	return op.document
+}
+
+func (op timeOperand) String() string {
+	return op.name + "=" + op.text
+}
+
+const (
+	timeCmpDoc  = `<op><age|time> compares the %s time with an age or a time, where <op> is one of <, <=, >, >= or =, eg. %s<7d matches files %s less than 7 days ago`
+	olderDoc    = `=<age|time> matches files that were modified before the specified age or time`
+	withinDoc   = `=<age> matches files that were %s within the specified age, eg. 1w`
+	sinceDoc    = `=<age|time> matches files that were %s on or after the specified time or within the specified age`
+	modifiedStr = "modified"
+	accessedStr = "accessed"
+	changedStr  = "changed"
+)
+
+func timeCmpDocFor(name, field, verb string) string {
+	return fmt.Sprintf(timeCmpDoc, field, name, verb)
+}
+
+// registerTimeOperands registers the operands that compare the
+// modification, access and change times of files with ages or
+// absolute times.
+func registerTimeOperands(parser *boolexpr.Parser) {
+	for _, op := range []struct {
+		name      string
+		field     timeField
+		fieldName string
+		verb      string
+		within    string
+		since     string
+	}{
+		{"mtime", modTime, "modification", modifiedStr, "modified-within", "modified-since"},
+		{"atime", accessTime, "access", accessedStr, "accessed-within", "accessed-since"},
+		{"ctime", changeTime, "change", changedStr, "changed-within", "changed-since"},
+	} {
+		op := op
+		parser.RegisterOperand(op.name, func(n, v string) boolexpr.Operand {
+			return newTimeOperand(n, v, op.field, "", "", "="+timeCmpDocFor(n, op.fieldName, op.verb))
+		})
+		parser.RegisterOperand(op.within, func(n, v string) boolexpr.Operand {
+			return newTimeOperand(n, v, op.field, "<=", "", fmt.Sprintf(withinDoc, op.verb))
+		})
+		parser.RegisterOperand(op.since, func(n, v string) boolexpr.Operand {
+			return newTimeOperand(n, v, op.field, "<=", ">=", fmt.Sprintf(sinceDoc, op.verb))
+		})
+	}
+	parser.RegisterOperand("older", func(n, v string) boolexpr.Operand {
+		return newTimeOperand(n, v, modTime, ">", "<", olderDoc)
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type timesTest struct {
+	mtime, atime, ctime time.Time
+}
+
+func (tt timesTest) ModTime() time.Time    { return tt.mtime }
+func (tt timesTest) AccessTime() time.Time { return tt.atime }
+func (tt timesTest) ChangeTime() time.Time { return tt.ctime }
+
+func TestParseAge(t *testing.T) {
+	day := 24 * time.Hour
+	for _, tc := range []struct {
+		text      string
+		age, unit time.Duration
+	}{
+		{"36h", 36 * time.Hour, time.Hour},
+		{"1.5d", 36 * time.Hour, day},
+		{"1w2d", 9 * day, day},
+		{"10m30s", 10*time.Minute + 30*time.Second, time.Second},
+	} {
+		age, unit, err := parseAge(tc.text)
+		if err != nil {
+			t.Errorf("%v: %v", tc.text, err)
+			continue
+		}
+		if got, want := age, tc.age; got != want {
+			t.Errorf("%v: got %v, want %v", tc.text, got, want)
+		}
+		if got, want := unit, tc.unit; got != want {
+			t.Errorf("%v: got %v, want %v", tc.text, got, want)
+		}
+	}
+	for _, text := range []string{"", "7", "d", "7x", "7d-"} {
+		if _, _, err := parseAge(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+func TestTimeOperands(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	defer func(fn func() time.Time) { timeNow = fn }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	day := 24 * time.Hour
+	recent := timesTest{mtime: now.Add(-time.Hour), atime: now.Add(-2 * time.Hour), ctime: now.Add(-3 * time.Hour)}
+	old := timesTest{mtime: now.Add(-30 * day), atime: now.Add(-8 * day), ctime: now.Add(-2*day - time.Hour)}
+
+	for _, tc := range []struct {
+		expr        string
+		recent, old bool
+	}{
+		{"mtime<7d", true, false},
+		{"mtime>7d", false, true},
+		{"mtime<=1h", true, false},
+		{"mtime>=30d", false, true},
+		{"older=36h", false, true},
+		{"older=2024-03-01", false, true},
+		{"mtime<2024-03-01", false, true},
+		{"mtime>2024-03-01", true, false},
+		{"mtime=2024-03-10", true, false},
+		{"mtime=2024-02-09", false, true},
+		{"modified-within=1w", true, false},
+		{"modified-since=2024-03-10", true, false},
+		{"modified-since=1d", true, false},
+		{"atime<1w", true, false},
+		{"atime>1w", false, true},
+		{"accessed-within=3h", true, false},
+		{"accessed-since=2024-03-01T00:00:00Z", true, true},
+		{"ctime=2d", false, true},
+		{"changed-within=2h", false, false},
+		{"changed-since=2024-03-09", true, false},
+		{"mtime<7d && atime<7d", true, false},
+	} {
+		expr := newExpr(t, tc.expr)
+		if got, want := expr.Eval(recent), tc.recent; got != want {
+			t.Errorf("%v: recent: got %v, want %v", tc.expr, got, want)
+		}
+		if got, want := expr.Eval(old), tc.old; got != want {
+			t.Errorf("%v: old: got %v, want %v", tc.expr, got, want)
+		}
+		if !expr.NeedsStat() {
+			t.Errorf("%v: should require stat", tc.expr)
+		}
+		if expr.Eval(entryType{name: "f", path: "f"}) {
+			t.Errorf("%v: should not match a value without times", tc.expr)
+		}
+	}
+
+	for _, expr := range []string{"mtime<7x", "older=yesterday", "modified-within=2024-03-10", "atime=<"} {
+		if _, err := createExpr([]string{expr}); err == nil {
+			t.Errorf("%v: expected an error", expr)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+func accessTimeFromSys(sys any) (time.Time, bool) {
+	if st, ok := sys.(*syscall.Stat_t); ok {
+		return time.Unix(st.Atimespec.Unix()), true
+	}
+	return time.Time{}, false
+}
+
+func changeTimeFromSys(sys any) (time.Time, bool) {
+	if st, ok := sys.(*syscall.Stat_t); ok {
+		return time.Unix(st.Ctimespec.Unix()), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,17 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "time"
+
+func accessTimeFromSys(any) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func changeTimeFromSys(any) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,23 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+func accessTimeFromSys(sys any) (time.Time, bool) {
+	if fa, ok := sys.(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, fa.LastAccessTime.Nanoseconds()), true
+	}
+	return time.Time{}, false
+}
+
+// changeTimeFromSys always fails on windows since the change time of a
+// file, as opposed to its creation time, is not available.
+func changeTimeFromSys(any) (time.Time, bool) {
+	return time.Time{}, false
+}
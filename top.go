@@ -0,0 +1,165 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/filewalk"
+)
+
+// topKey returns the value used to rank a match.
+type topKey func(v visit, path string, fi *file.Info) int64
+
+var topKeys = map[string]topKey{
+	"size": func(_ visit, _ string, fi *file.Info) int64 {
+		return fi.Size()
+	},
+	"mtime": func(_ visit, _ string, fi *file.Info) int64 {
+		return fi.ModTime().UnixNano()
+	},
+	"atime": func(_ visit, _ string, fi *file.Info) int64 {
+		t, _ := accessTimeFromSys(fi.Sys())
+		return t.UnixNano()
+	},
+	// The number of entries in a directory is determined by the walker
+	// once it has scanned that directory, see topMatches.dirEntries.
+	"entries": func(_ visit, _ string, _ *file.Info) int64 {
+		return 0
+	},
+}
+
+// parseTopKey parses the value of --by, a leading - selects the
+// smallest, or oldest, rather than the largest, or newest, matches.
+func parseTopKey(by string) (topKey, bool, error) {
+	name := strings.TrimPrefix(by, "-")
+	key, ok := topKeys[name]
+	if !ok {
+		return nil, false, fmt.Errorf("unsupported --by value: %q, use one of size, mtime, atime or entries, optionally preceded by -", by)
+	}
+	return key, len(name) != len(by), nil
+}
+
+// topMatch is a match retained by topMatches along with the visit
+// used to display it once the search is complete.
+type topMatch struct {
+	key                int64
+	v                  visit
+	path, parent, name string
+	entry              filewalk.Entry
+	fi                 *file.Info
+}
+
+// topHeap is a heap of matches ordered such that the worst of them is
+// at the root.
+type topHeap struct {
+	matches []topMatch
+	reverse bool
+}
+
+// better returns true if a ranks higher than b, ties are broken using
+// the path so that the results are deterministic.
+func (h *topHeap) better(a, b topMatch) bool {
+	if a.key != b.key {
+		return (a.key > b.key) != h.reverse
+	}
+	return a.path < b.path
+}
+
+func (h *topHeap) Len() int           { return len(h.matches) }
+func (h *topHeap) Less(i, j int) bool { return h.better(h.matches[j], h.matches[i]) }
+func (h *topHeap) Swap(i, j int)      { h.matches[i], h.matches[j] = h.matches[j], h.matches[i] }
+func (h *topHeap) Push(x any)         { h.matches = append(h.matches, x.(topMatch)) }
+func (h *topHeap) Pop() any {
+	n := len(h.matches)
+	m := h.matches[n-1]
+	h.matches = h.matches[:n-1]
+	return m
+}
+
+// topMatches retains the best n matches as ranked by a topKey in a
+// bounded heap. When ranking by the number of entries, directories
+// are retained as pending until the walker has scanned them and hence
+// determined the number of entries they contain.
+type topMatches struct {
+	mu        sync.Mutex
+	n         int
+	key       topKey
+	byEntries bool
+	pending   map[string]topMatch
+	heap      topHeap
+}
+
+func newTopMatches(n int, by string) (*topMatches, error) {
+	key, reverse, err := parseTopKey(by)
+	if err != nil {
+		return nil, err
+	}
+	return &topMatches{
+		n:         n,
+		key:       key,
+		byEntries: strings.TrimPrefix(by, "-") == "entries",
+		pending:   map[string]topMatch{},
+		heap:      topHeap{reverse: reverse},
+	}, nil
+}
+
+func (t *topMatches) add(v visit, path, parent, name string, entry filewalk.Entry, fi *file.Info) {
+	m := topMatch{v: v, path: path, parent: parent, name: name, entry: entry, fi: fi}
+	m.key = t.key(v, path, fi)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byEntries && fi.IsDir() {
+		t.pending[path] = m
+		return
+	}
+	t.push(m)
+}
+
+// dirEntries is called by the walker with the number of entries in
+// a directory once it has been scanned.
+func (t *topMatches) dirEntries(path string, numEntries int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.pending[path]
+	if !ok {
+		return
+	}
+	delete(t.pending, path)
+	m.key = numEntries
+	t.push(m)
+}
+
+func (t *topMatches) push(m topMatch) {
+	if t.heap.Len() < t.n {
+		heap.Push(&t.heap, m)
+		return
+	}
+	if t.heap.better(m, t.heap.matches[0]) {
+		t.heap.matches[0] = m
+		heap.Fix(&t.heap, 0)
+	}
+}
+
+// sorted returns the retained matches, best first. Directories that
+// were not scanned, eg. because of --depth, rank as having no entries.
+func (t *topMatches) sorted() []topMatch {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for path, m := range t.pending {
+		delete(t.pending, path)
+		t.push(m)
+	}
+	matches := append([]topMatch{}, t.heap.matches...)
+	sort.Slice(matches, func(i, j int) bool {
+		return t.heap.better(matches[i], matches[j])
+	})
+	return matches
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const topSpec = `
+name: r
+entries:
+  - file:
+	  name: f0
+	  size: 10
+	  time: 2024-01-03T00:00:00Z
+  - file:
+	  name: f1
+	  size: 40
+	  time: 2024-01-01T00:00:00Z
+  - dir:
+	  name: d0
+	  time: 2024-01-05T00:00:00Z
+	  entries:
+		- file:
+			name: f2
+			size: 30
+			time: 2024-01-02T00:00:00Z
+		- file:
+			name: f3
+			size: 20
+			time: 2024-01-04T00:00:00Z
+		- file:
+			name: f4
+			size: 20
+			time: 2024-01-06T00:00:00Z
+`
+
+func TestTop(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		top    int
+		by     string
+		expr   string
+		output string
+	}{
+		{2, "size", "type=f", "r/f1,r/d0/f2"},
+		{3, "size", "type=f", "r/f1,r/d0/f2,r/d0/f3"},
+		{2, "-size", "type=f", "r/f0,r/d0/f3"},
+		{10, "size", "type=f", "r/f1,r/d0/f2,r/d0/f3,r/d0/f4,r/f0"},
+		{2, "mtime", "type=f", "r/d0/f4,r/d0/f3"},
+		{2, "-mtime", "type=f", "r/f1,r/d0/f2"},
+		{1, "mtime", "", "r/d0/f4"},
+		{1, "entries", "type=d", "r"},
+		{2, "entries", "type=d", "r,r/d0"},
+	} {
+		for _, sorted := range []bool{false, true} {
+			lf := &locateFlags{Sorted: sorted, Depth: -1, Format: textFormat, Top: tc.top, By: tc.by}
+			lf.ScanSize = 1
+			args := []string{"r"}
+			if len(tc.expr) > 0 {
+				args = append(args, tc.expr)
+			}
+			out, errs := locateOutput(ctx, t, lf, topSpec, args...)
+			if len(errs) > 0 {
+				t.Errorf("unexpected errors: %v", errs)
+			}
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			if got, want := strings.Join(lines, ","), tc.output; got != want {
+				t.Errorf("%v %v %v: sorted %v: got %v, want %v", tc.top, tc.by, tc.expr, sorted, got, want)
+			}
+		}
+	}
+
+	// The number of entries in each directory is determined by the walker,
+	// directories that are not scanned rank as having none.
+	for _, tc := range []struct {
+		top      int
+		by       string
+		excluded string
+		output   string
+	}{
+		{3, "entries", "", "r,r/src,r/node_modules"},
+		{2, "-entries", "", "r/.git,r/node_modules/m"},
+		{1, "-entries", "src", "r/src"},
+	} {
+		for _, sorted := range []bool{false, true} {
+			lf := &locateFlags{Sorted: sorted, Depth: -1, Format: textFormat, Top: tc.top, By: tc.by}
+			if len(tc.excluded) > 0 {
+				lf.ExcludeNames = repeating(tc.excluded)
+			}
+			lf.ScanSize = 1
+			out, errs := locateOutput(ctx, t, lf, pruneSpec, "r", "type=d")
+			if len(errs) > 0 {
+				t.Errorf("unexpected errors: %v", errs)
+			}
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			if got, want := strings.Join(lines, ","), tc.output; got != want {
+				t.Errorf("%v %v %v: sorted %v: got %v, want %v", tc.top, tc.by, tc.excluded, sorted, got, want)
+			}
+		}
+	}
+
+	for _, lf := range []*locateFlags{
+		{Top: 1, By: "name", Format: textFormat},
+		{Top: -1, By: "size", Format: textFormat},
+		{Top: 1, By: "size", Limit: 1, Format: textFormat},
+		{Top: 1, By: "size", Delete: true, Format: textFormat},
+	} {
+		if err := lf.validate(); err == nil {
+			t.Errorf("%+v: expected an error", lf)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/diskusage"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/asyncstat"
+)
+
+type usageCmd struct{}
+
+type usageFlags struct {
+	WalkerFlags
+	Depth           int    `subcmd:"depth,1,'report the usage of directories at or above the specified depth, the starting location is at depth 0'"`
+	Top             int    `subcmd:"top,0,'only report the specified number of directories with the largest usage, 0 reports all of them'"`
+	By              string `subcmd:"by,bytes,'the usage to sort by, largest first, one of bytes, blocks, files or dirs'"`
+	SameDevice      bool   `subcmd:"same-device,true,only search directories on the same device as the starting directory"`
+	FollowSoftLinks bool   `subcmd:"follow-softlinks,false,follow softlinks"`
+	Format          string `subcmd:"format,text,'output format, one of text, json (an array of objects) or ndjson (one object per line)'"`
+	QuietErrors     string `subcmd:"quiet-errors,,'comma separated list of the classes of error that are not displayed as they are encountered, as per locate --quiet-errors'"`
+}
+
+var usageKeys = map[string]func(u usageRecord) int64{
+	"bytes":  func(u usageRecord) int64 { return u.Bytes },
+	"blocks": func(u usageRecord) int64 { return u.Blocks },
+	"files":  func(u usageRecord) int64 { return u.Files },
+	"dirs":   func(u usageRecord) int64 { return u.Dirs },
+}
+
+func (uf *usageFlags) validate() error {
+	if err := validateFormat(uf.Format); err != nil {
+		return err
+	}
+	if _, ok := usageKeys[uf.By]; !ok {
+		return fmt.Errorf("unsupported --by value: %q, use one of bytes, blocks, files or dirs", uf.By)
+	}
+	if uf.Top < 0 {
+		return fmt.Errorf("--top must be positive")
+	}
+	if _, err := parseErrorClasses(uf.QuietErrors); err != nil {
+		return err
+	}
+	return nil
+}
+
+// usageRecord represents the usage of a single directory, including
+// all of the files and directories below it. Bytes and blocks refer to
+// files only, ie. all entries other than directories, and files with
+// multiple hard links are only counted once.
+type usageRecord struct {
+	Path   string `json:"path"`
+	Depth  int    `json:"depth"`
+	Bytes  int64  `json:"bytes"`
+	Blocks int64  `json:"blocks"`
+	Files  int64  `json:"files"`
+	Dirs   int64  `json:"dirs"`
+}
+
+// usageNode accumulates the usage of a single directory and, once all
+// of its subdirectories have been walked, that of its descendants.
+type usageNode struct {
+	parent                     *usageNode
+	path                       string
+	depth                      int
+	bytes, blocks, files, dirs atomic.Int64
+}
+
+func (n *usageNode) add(o *usageNode) {
+	n.bytes.Add(o.bytes.Load())
+	n.blocks.Add(o.blocks.Load())
+	n.files.Add(o.files.Load())
+	n.dirs.Add(o.dirs.Load())
+}
+
+func (n *usageNode) record() usageRecord {
+	return usageRecord{
+		Path:   n.path,
+		Depth:  n.depth,
+		Bytes:  n.bytes.Load(),
+		Blocks: n.blocks.Load(),
+		Files:  n.files.Load(),
+		Dirs:   n.dirs.Load(),
+	}
+}
+
+// fileKey identifies a file with multiple hard links.
+type fileKey struct {
+	device, inode uint64
+}
+
+type usageState struct {
+	node *usageNode
+}
+
+// usageWalker implements filewalk.Handler to aggregate the usage of
+// each directory. The usage of a directory is added to that of its
+// parent once all of its subdirectories have been walked, ie. when
+// Done is called for it.
+type usageWalker struct {
+	fs           filewalk.FS
+	stats        *asyncstat.T
+	out          *output
+	outcome      *outcome
+	depth        int
+	isSameDevice sameDevice
+	// pending records the node for each directory that is yet to be
+	// walked.
+	pending sync.Map
+	links   sync.Map
+
+	mu      sync.Mutex
+	results []usageRecord
+}
+
+func (uw *usageWalker) Prefix(ctx context.Context, state *usageState, prefix string, fi file.Info, err error) (bool, file.InfoList, error) {
+	n, ok := uw.pending.LoadAndDelete(prefix)
+	if ok {
+		state.node = n.(*usageNode)
+	} else {
+		state.node = &usageNode{path: prefix}
+	}
+	if err != nil {
+		uw.error(prefix, err)
+		return true, nil, nil
+	}
+	if !ok && !fi.IsDir() {
+		// The starting location is a file.
+		uw.addFile(ctx, state.node, prefix, fi)
+		uw.done(state.node)
+		return true, nil, nil
+	}
+	same, err := uw.isSameDevice.Match(ctx, uw.fs, prefix, fi)
+	if err != nil {
+		uw.error(prefix, err)
+		return true, nil, nil
+	}
+	return !same, nil, nil
+}
+
+func (uw *usageWalker) addFile(ctx context.Context, n *usageNode, path string, fi file.Info) {
+	xattr, err := uw.fs.XAttr(ctx, path, fi)
+	if err != nil {
+		uw.error(path, err)
+	}
+	if xattr.Hardlinks > 1 {
+		key := fileKey{device: xattr.Device, inode: xattr.FileID}
+		if _, loaded := uw.links.LoadOrStore(key, struct{}{}); loaded {
+			return
+		}
+	}
+	n.files.Add(1)
+	n.bytes.Add(fi.Size())
+	n.blocks.Add(xattr.Blocks)
+}
+
+func (uw *usageWalker) Contents(ctx context.Context, state *usageState, prefix string, contents []filewalk.Entry) (file.InfoList, error) {
+	children, all, err := uw.stats.Process(ctx, prefix, contents)
+	if err != nil {
+		uw.error(prefix, err)
+		return nil, nil
+	}
+	n := state.node
+	for _, info := range all {
+		if info.IsDir() {
+			n.dirs.Add(1)
+			continue
+		}
+		uw.addFile(ctx, n, uw.fs.Join(prefix, info.Name()), info)
+	}
+	for _, c := range children {
+		path := uw.fs.Join(prefix, c.Name())
+		uw.pending.Store(path, &usageNode{parent: n, path: path, depth: n.depth + 1})
+	}
+	return children, nil
+}
+
+func (uw *usageWalker) Done(_ context.Context, state *usageState, prefix string, err error) error {
+	if err != nil {
+		uw.error(prefix, err)
+	}
+	uw.done(state.node)
+	return nil
+}
+
+// error records and, unless suppressed by --quiet-errors, displays an
+// error so that directories whose usage is incomplete are reported in
+// the summary of errors and the exit status.
+func (uw *usageWalker) error(path string, err error) {
+	if uw.outcome.error(classifyError(uw.fs, err), path) {
+		uw.out.error(path, err)
+	}
+}
+
+// done records the usage of a directory, if it is to be reported, and
+// adds it to that of its parent.
+func (uw *usageWalker) done(n *usageNode) {
+	if n.depth <= uw.depth {
+		uw.mu.Lock()
+		uw.results = append(uw.results, n.record())
+		uw.mu.Unlock()
+		uw.outcome.matched()
+	}
+	if n.parent != nil {
+		n.parent.add(n)
+	}
+}
+
+// sortUsage sorts the usage records by the specified key, largest first,
+// and then by path, and returns at most top of them if top is non-zero.
+func sortUsage(results []usageRecord, by string, top int) []usageRecord {
+	key := usageKeys[by]
+	sort.Slice(results, func(i, j int) bool {
+		if ki, kj := key(results[i]), key(results[j]); ki != kj {
+			return ki > kj
+		}
+		return results[i].Path < results[j].Path
+	})
+	if top > 0 && len(results) > top {
+		results = results[:top]
+	}
+	return results
+}
+
+func (uc usageCmd) usage(ctx context.Context, values interface{}, args []string) error {
+	uf := values.(*usageFlags)
+	if err := uf.validate(); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no directories specified")
+	}
+	var filesystems fileSystems
+	groups, err := filesystems.group(ctx, args)
+	if err != nil {
+		return err
+	}
+	quiet, err := parseErrorClasses(uf.QuietErrors)
+	if err != nil {
+		return err
+	}
+	out := newOutput(os.Stdout, os.Stderr, uf.Format, false)
+	oc := newOutcome(quiet)
+	var results []usageRecord
+	for _, g := range groups {
+		r, err := uc.usageFS(ctx, g.fs, uf, out, oc, g.roots)
+		if err != nil {
+			return err
+		}
+		results = append(results, r...)
+	}
+	out.begin()
+	for _, r := range sortUsage(results, uf.By, uf.Top) {
+		if out.format != textFormat {
+			out.object(r.Path, r)
+			continue
+		}
+		out.text(fmt.Sprintf("%12s %12s %10d %10d %v",
+			diskusage.BinarySize(0, 2, r.Bytes),
+			diskusage.BinarySize(0, 2, r.Blocks*512),
+			r.Files, r.Dirs, r.Path))
+	}
+	out.end()
+	if uf.Format == textFormat {
+		oc.summarize(out)
+	}
+	return oc.err()
+}
+
+func (uc usageCmd) usageFS(ctx context.Context, wkfs filewalk.FS, uf *usageFlags, out *output, oc *outcome, roots []string) ([]usageRecord, error) {
+	roots = uniqueRoots(wkfs, roots)
+	fwo, aso := uf.WalkerFlags.walkOptions(uf.FollowSoftLinks)
+	uw := &usageWalker{
+		fs:      wkfs,
+		stats:   asyncstat.New(wkfs, aso...),
+		out:     out,
+		outcome: oc,
+		depth:   uf.Depth,
+	}
+	if uf.SameDevice {
+		sd, err := newSameDevice(ctx, wkfs, roots...)
+		if err != nil {
+			return nil, err
+		}
+		uw.isSameDevice = sd
+	}
+	err := filewalk.New(wkfs, uw, fwo...).Walk(ctx, roots...)
+	return uw.results, err
+}
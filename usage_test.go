@@ -0,0 +1,167 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloudeng.io/file/filewalk/filewalktestutil"
+	"cloudeng.io/file/localfs"
+)
+
+const usageSpec = `
+name: r
+entries:
+  - file:
+	  name: f0
+	  size: 10
+  - dir:
+	  name: d0
+	  entries:
+		- file:
+			name: f1
+			size: 20
+		- dir:
+			name: d1
+			entries:
+			  - file:
+				  name: f2
+				  size: 30
+  - dir:
+	  name: d2
+`
+
+func TestUsage(t *testing.T) {
+	ctx := context.Background()
+	fs, err := filewalktestutil.NewMockFS("r", filewalktestutil.WithYAMLConfig(usageSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		depth, top int
+		by         string
+		expected   []usageRecord
+	}{
+		{1, 0, "bytes", []usageRecord{
+			{Path: "r", Depth: 0, Bytes: 60, Files: 3, Dirs: 3},
+			{Path: "r/d0", Depth: 1, Bytes: 50, Files: 2, Dirs: 1},
+			{Path: "r/d2", Depth: 1},
+		}},
+		{0, 0, "bytes", []usageRecord{
+			{Path: "r", Depth: 0, Bytes: 60, Files: 3, Dirs: 3},
+		}},
+		{2, 2, "files", []usageRecord{
+			{Path: "r", Depth: 0, Bytes: 60, Files: 3, Dirs: 3},
+			{Path: "r/d0", Depth: 1, Bytes: 50, Files: 2, Dirs: 1},
+		}},
+		{2, 0, "dirs", []usageRecord{
+			{Path: "r", Depth: 0, Bytes: 60, Files: 3, Dirs: 3},
+			{Path: "r/d0", Depth: 1, Bytes: 50, Files: 2, Dirs: 1},
+			{Path: "r/d0/d1", Depth: 2, Bytes: 30, Files: 1},
+			{Path: "r/d2", Depth: 1},
+		}},
+	} {
+		uf := &usageFlags{Depth: tc.depth, Top: tc.top, By: tc.by, Format: textFormat}
+		uf.ScanSize = 1
+		var out, errs bytes.Buffer
+		o := newOutput(&out, &errs, textFormat, false)
+		results, err := (usageCmd{}).usageFS(ctx, fs, uf, o, newOutcome(nil), []string{"r"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs.Len() > 0 {
+			t.Errorf("unexpected errors: %v", errs.String())
+		}
+		if got, want := sortUsage(results, tc.by, tc.top), tc.expected; !reflect.DeepEqual(got, want) {
+			t.Errorf("depth %v, top %v: got %v, want %v", tc.depth, tc.top, got, want)
+		}
+	}
+
+	for _, uf := range []*usageFlags{
+		{By: "size", Format: textFormat},
+		{By: "bytes", Format: "xml"},
+		{By: "bytes", Format: textFormat, Top: -1},
+		{By: "bytes", Format: textFormat, QuietErrors: "nosuch"},
+	} {
+		if err := uf.validate(); err == nil {
+			t.Errorf("%+v: expected an error", uf)
+		}
+	}
+}
+
+func TestUsageHardlinks(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "a", "b"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(tmpDir, "a", "f")
+	if err := os.WriteFile(file, make([]byte, 1000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(file, filepath.Join(tmpDir, "a", "b", "g")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "h"), make([]byte, 10), 0600); err != nil {
+		t.Fatal(err)
+	}
+	uf := &usageFlags{Depth: 0, By: "bytes", Format: textFormat}
+	var out, errs bytes.Buffer
+	o := newOutput(&out, &errs, textFormat, false)
+	results, err := (usageCmd{}).usageFS(ctx, localfs.New(), uf, o, newOutcome(nil), []string{tmpDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	r := results[0]
+	if got, want := r.Bytes, int64(1010); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := r.Files, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := r.Dirs, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		quiet     string
+		displayed int
+		err       error
+	}{
+		{"", 2, errSearchErrors},
+		{"permission", 0, nil},
+	} {
+		quiet, err := parseErrorClasses(tc.quiet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uf := &usageFlags{Depth: 0, By: "bytes", Format: textFormat}
+		var out, errs bytes.Buffer
+		o := newOutput(&out, &errs, textFormat, false)
+		oc := newOutcome(quiet)
+		if _, err := (usageCmd{}).usageFS(ctx, localfs.New(), uf, o, oc, []string{localTestTree}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Count(errs.String(), "\n"), tc.displayed; got != want {
+			t.Errorf("quiet %q: errors displayed: got %v, want %v: %v", tc.quiet, got, want, errs.String())
+		}
+		if err := oc.err(); !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+			t.Errorf("quiet %q: got %v, want %v", tc.quiet, err, tc.err)
+		}
+	}
+}
@@ -6,27 +6,40 @@ package main
 
 import (
 	"context"
+	"sync"
 
 	"cloudeng.io/file"
 	"cloudeng.io/file/filewalk"
-	"cloudeng.io/file/filewalk/asyncstat"
 )
 
 type walker struct {
 	expr  expression
-	stats *asyncstat.T
+	stats statProcessor
 	fs    filewalk.FS
 	visit visitor
 	walkerOptions
+	// pending records the state inherited by each directory that is yet
+	// to be walked, ie. its depth and gitignore rules, since the
+	// filewalk.Walker does not provide it.
+	pending   sync.Map
+	walkStats *walkStats
 }
 
 type walkerOptions struct {
 	needsStat       bool
 	followSoftLinks bool
 	scanSize        int
-	exclude         exclusions
+	exclude         *exclusions
 	isSameDevice    sameDevice
 	depth           int
+	postDir         func(ctx context.Context, path string)
+	dirEntries      func(path string, numEntries int64)
+	content         *contentEvaluator
+	gitignore       *gitignore
+	exactNumEntries bool
+	maxBuffered     int
+	minDepth        int
+	lookaheadDirs   int
 }
 
 type walkerOption func(o *walkerOptions)
@@ -55,7 +68,7 @@ func withSameDevice(sd sameDevice) walkerOption {
 	}
 }
 
-func withExclusions(ex exclusions) walkerOption {
+func withExclusions(ex *exclusions) walkerOption {
 	return func(wo *walkerOptions) {
 		wo.exclude = ex
 	}
@@ -67,16 +80,137 @@ func withDepth(d int) walkerOption {
 	}
 }
 
+func withMinDepth(d int) walkerOption {
+	return func(wo *walkerOptions) {
+		wo.minDepth = d
+	}
+}
+
+// withLookahead specifies the number of directories that the
+// depth-first walker may scan ahead of the directory being walked.
+func withLookahead(n int) walkerOption {
+	return func(wo *walkerOptions) {
+		wo.lookaheadDirs = n
+	}
+}
+
+// withPostDir specifies a function to be called for every directory
+// once all of its contents, including any subdirectories, have been
+// walked.
+func withPostDir(fn func(ctx context.Context, path string)) walkerOption {
+	return func(wo *walkerOptions) {
+		wo.postDir = fn
+	}
+}
+
+// withDirEntries specifies a function to be called with the number of
+// entries in every directory once all of them have been scanned.
+func withDirEntries(fn func(path string, numEntries int64)) walkerOption {
+	return func(wo *walkerOptions) {
+		wo.dirEntries = fn
+	}
+}
+
+// withContentEvaluator specifies the contentEvaluator to use for
+// expressions that refer to the contents of files.
+func withContentEvaluator(ce *contentEvaluator) walkerOption {
+	return func(wo *walkerOptions) {
+		wo.content = ce
+	}
+}
+
+// withGitignore specifies that .gitignore, .ignore and .git/info/exclude
+// files are to be honoured.
+func withGitignore(g *gitignore) walkerOption {
+	return func(wo *walkerOptions) {
+		wo.gitignore = g
+	}
+}
+
+// withExactNumEntries specifies that the number of entries in a
+// directory is to be determined before any of those entries are
+// evaluated. Up to maxBuffered entries per directory may be buffered
+// to avoid scanning the directory twice.
+func withExactNumEntries(maxBuffered int) walkerOption {
+	return func(wo *walkerOptions) {
+		wo.exactNumEntries = true
+		wo.maxBuffered = maxBuffered
+	}
+}
+
+// countEntries returns the number of entries in dir. If the directory
+// contains no more than maxBuffered entries then they are also returned
+// and complete is true.
+func countEntries(ctx context.Context, fs filewalk.FS, dir string, scanSize, maxBuffered int) (n int64, buffered []filewalk.Entry, complete bool, err error) {
+	sc := fs.LevelScanner(dir)
+	for sc.Scan(ctx, scanSize) {
+		contents := sc.Contents()
+		n += int64(len(contents))
+		if n > int64(maxBuffered) {
+			buffered = nil
+			continue
+		}
+		buffered = append(buffered, contents...)
+	}
+	if err := sc.Err(); err != nil {
+		return 0, nil, false, err
+	}
+	return n, buffered, n <= int64(maxBuffered), nil
+}
+
+// reportable returns true if matches at the specified depth are to be
+// reported.
+func (wo walkerOptions) reportable(depth int) bool {
+	return depth >= wo.minDepth
+}
+
+// evaluate returns true if the expression needs to be evaluated for
+// entries at the specified depth, either because matches are to be
+// reported or to determine which directories are to be pruned.
+func (wo walkerOptions) evaluate(expr expression, depth int) bool {
+	return wo.reportable(depth) || expr.pruneOperands > 0
+}
+
+// matchRoot evaluates the expression against the starting location,
+// which is at depth 0, and reports it if it matches. Directories
+// below the starting location are evaluated as entries of their
+// parent directory. It returns true if the starting location is
+// to be pruned.
+func (wo walkerOptions) matchRoot(ctx context.Context, expr expression, fs filewalk.FS, path string, info file.Info, visit visitor) bool {
+	if !wo.evaluate(expr, 0) {
+		return false
+	}
+	vals := []withStat{{
+		ctx:  ctx,
+		name: info.Name(),
+		path: path,
+		fs:   fs,
+		info: info,
+	}}
+	report := func(path string, err error) {
+		visit(path, "", filewalk.Entry{}, nil, err)
+	}
+	matched, pruned := evalValues(ctx, expr, wo.content, vals, report, nil)
+	if matched[0] && wo.reportable(0) {
+		visit(path, "", filewalk.Entry{Name: info.Name(), Type: info.Mode()}, &info, nil)
+	}
+	return pruned[0]
+}
+
 type dirstate struct {
 	numEntries int64
+	depth      int
+	ignore     *ignoreRules
+	pruned     bool
 }
 
-func newWalker(expr expression, fs filewalk.FS, stats *asyncstat.T, fileWalkerOpts []filewalk.Option, walkerOpts []walkerOption, visit visitor) *filewalk.Walker[dirstate] {
+func newWalker(expr expression, fs filewalk.FS, stats statProcessor, fileWalkerOpts []filewalk.Option, walkerOpts []walkerOption, visit visitor) *filewalk.Walker[dirstate] {
 	w := &walker{
-		expr:  expr,
-		fs:    fs,
-		stats: stats,
-		visit: visit,
+		expr:      expr,
+		fs:        fs,
+		stats:     stats,
+		visit:     visit,
+		walkStats: statsFor(fs),
 	}
 	w.walkerOptions.depth = -1
 	for _, opt := range walkerOpts {
@@ -85,12 +219,27 @@ func newWalker(expr expression, fs filewalk.FS, stats *asyncstat.T, fileWalkerOp
 	return filewalk.New(fs, w, fileWalkerOpts...)
 }
 
-func (w *walker) Prefix(ctx context.Context, _ *dirstate, prefix string, fi file.Info, err error) (bool, file.InfoList, error) {
+func (w *walker) Prefix(ctx context.Context, state *dirstate, prefix string, fi file.Info, err error) (bool, file.InfoList, error) {
+	ps, ok := w.pending.LoadAndDelete(prefix)
+	if ok {
+		*state = ps.(dirstate)
+		w.walkStats.queue(-1)
+	}
 	if err != nil {
 		w.visit(prefix, "", filewalk.Entry{}, &fi, err)
 		return true, nil, nil
 	}
-	if w.exclude.Match(prefix) {
+	if !ok {
+		// Only the starting location has no pending state.
+		state.pruned = w.matchRoot(ctx, w.expr, w.fs, prefix, fi, w.visit)
+		if !fi.IsDir() {
+			return true, nil, nil
+		}
+	}
+	if state.pruned {
+		return true, nil, nil
+	}
+	if w.exclude.Match(prefix, state.depth) {
 		return true, nil, nil
 	}
 	same, err := w.isSameDevice.Match(ctx, w.fs, prefix, fi)
@@ -101,79 +250,173 @@ func (w *walker) Prefix(ctx context.Context, _ *dirstate, prefix string, fi file
 	if !same {
 		return true, nil, nil
 	}
-	ws := withStat{
-		ctx:        ctx,
-		name:       fi.Name(),
-		path:       prefix,
-		fs:         w.fs,
-		info:       fi,
-		numEntries: 0, // num entries is zero now.
+	if w.gitignore != nil {
+		if state.ignore, err = w.gitignore.load(ctx, state.ignore, prefix); err != nil {
+			w.visit(prefix, "", filewalk.Entry{}, nil, err)
+		}
 	}
-	if w.expr.Eval(ws) {
-		return false, nil, nil
+	if w.exactNumEntries {
+		// The filewalk.Walker scans the directory itself and hence
+		// the entries are counted in advance of that scan.
+		if state.numEntries, _, _, err = countEntries(ctx, w.fs, prefix, w.scanSize, 0); err != nil {
+			return false, nil, err
+		}
 	}
 	return false, nil, nil
 }
 
-func (w *walker) withoutStat(ctx context.Context, state *dirstate, prefix string, contents []filewalk.Entry) (file.InfoList, error) {
+func (w *walker) withoutStat(ctx context.Context, state *dirstate, prefix string, contents []filewalk.Entry) (file.InfoList, map[string]bool, error) {
 	var dirs []filewalk.Entry
-	for _, e := range contents {
+	vals := make([]entryType, len(contents))
+	for i, e := range contents {
 		if e.IsDir() {
 			dirs = append(dirs, e)
 		}
-		wn := entryType{
+		vals[i] = entryType{
 			name:       e.Name,
 			path:       w.fs.Join(prefix, e.Name),
 			mode:       e.Type,
 			numEntries: state.numEntries,
+			depth:      state.depth + 1,
 		}
-		if !w.expr.Eval(wn) {
-			continue
+	}
+	var pruned map[string]bool
+	if w.evaluate(w.expr, state.depth+1) {
+		visit := func(i int) {
+			if w.exclude.Included(vals[i].path, state.depth+1) {
+				w.visit(prefix, contents[i].Name, contents[i], nil, nil)
+			}
+		}
+		matches, prune := evalValues(ctx, w.expr, w.content, vals, w.reportError, w.matchedLater(state.depth+1, visit))
+		pruned = prunedNames(vals, prune)
+		if w.reportable(state.depth + 1) {
+			for i, matched := range matches {
+				if matched {
+					visit(i)
+				}
+			}
 		}
-		w.visit(prefix, e.Name, e, nil, nil)
 	}
 	children, _, err := w.stats.Process(ctx, prefix, dirs)
 	if err != nil {
 		w.visit(prefix, "", filewalk.Entry{}, nil, err)
 	}
-	return children, nil
+	return children, pruned, nil
 }
 
-func (w *walker) withStat(ctx context.Context, state *dirstate, prefix string, contents []filewalk.Entry) (file.InfoList, error) {
+func (w *walker) withStat(ctx context.Context, state *dirstate, prefix string, contents []filewalk.Entry) (file.InfoList, map[string]bool, error) {
 	children, all, err := w.stats.Process(ctx, prefix, contents)
 	if err != nil {
 		w.visit(prefix, "", filewalk.Entry{}, nil, err)
-		return nil, nil
+		return nil, nil, nil
 	}
-	for _, info := range all {
-		ws := withStat{
+	vals := make([]withStat, len(all))
+	for i, info := range all {
+		vals[i] = withStat{
 			ctx:        ctx,
 			name:       info.Name(),
 			path:       w.fs.Join(prefix, info.Name()),
 			fs:         w.fs,
 			info:       info,
 			numEntries: state.numEntries,
+			depth:      state.depth + 1,
 		}
-		if w.expr.Eval(ws) {
+	}
+	var pruned map[string]bool
+	if w.evaluate(w.expr, state.depth+1) {
+		visit := func(i int) {
+			if !w.exclude.Included(vals[i].path, state.depth+1) {
+				return
+			}
+			info := all[i]
 			w.visit(prefix, info.Name(),
 				filewalk.Entry{Name: info.Name(), Type: info.Type()}, &info, nil)
 		}
+		matches, prune := evalValues(ctx, w.expr, w.content, vals, w.reportError, w.matchedLater(state.depth+1, visit))
+		pruned = prunedNames(vals, prune)
+		if w.reportable(state.depth + 1) {
+			for i, matched := range matches {
+				if matched {
+					visit(i)
+				}
+			}
+		}
 	}
-	return children, nil
+	return children, pruned, nil
+}
+
+// matchedLater returns the function used to visit the files that are
+// found to match once their contents have been read, which happens
+// asynchronously so that reading files does not delay scanning
+// directories. It returns nil, so that files are read before the walk
+// proceeds, when the contents of a directory must all have been visited
+// before postDir is called for it.
+func (w *walker) matchedLater(depth int, visit func(i int)) func(i int) {
+	if w.postDir != nil {
+		return nil
+	}
+	if !w.reportable(depth) {
+		return func(int) {}
+	}
+	return visit
+}
+
+// prunedNames returns the names of the values that are to be pruned,
+// or nil if there are none.
+func prunedNames[T interface{ Name() string }](vals []T, pruned []bool) map[string]bool {
+	var names map[string]bool
+	for i, p := range pruned {
+		if !p {
+			continue
+		}
+		if names == nil {
+			names = map[string]bool{}
+		}
+		names[vals[i].Name()] = true
+	}
+	return names
+}
+
+func (w *walker) reportError(path string, err error) {
+	w.visit(path, "", filewalk.Entry{}, nil, err)
 }
 
 func (w *walker) Contents(ctx context.Context, state *dirstate, prefix string, contents []filewalk.Entry) (file.InfoList, error) {
-	state.numEntries += int64(len(contents))
+	if !w.exactNumEntries {
+		state.numEntries += int64(len(contents))
+	}
+	if w.gitignore != nil {
+		contents = w.gitignore.filter(state.ignore, prefix, contents)
+	}
+	var children file.InfoList
+	var pruned map[string]bool
+	var err error
 	if w.needsStat {
-		return w.withStat(ctx, state, prefix, contents)
+		children, pruned, err = w.withStat(ctx, state, prefix, contents)
+	} else {
+		children, pruned, err = w.withoutStat(ctx, state, prefix, contents)
 	}
-	return w.withoutStat(ctx, state, prefix, contents)
+	if w.depth < 0 || state.depth < w.depth {
+		// Pruned directories are skipped by Prefix.
+		w.walkStats.queue(len(children))
+		for _, c := range children {
+			w.pending.Store(w.fs.Join(prefix, c.Name()),
+				dirstate{depth: state.depth + 1, ignore: state.ignore, pruned: pruned[c.Name()]})
+		}
+	}
+	return children, err
 }
 
-func (w *walker) Done(ctx context.Context, _ *dirstate, prefix string, err error) error {
+func (w *walker) Done(ctx context.Context, state *dirstate, prefix string, err error) error {
 	if err != nil {
 		w.visit(prefix, "", filewalk.Entry{}, nil, err)
 		return nil
 	}
+	if w.dirEntries != nil {
+		w.dirEntries(prefix, state.numEntries)
+	}
+	if w.postDir != nil {
+		w.postDir(ctx, prefix)
+	}
 	return nil
 }
@@ -0,0 +1,255 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloudeng.io/file"
+	"cloudeng.io/file/diskusage"
+	"cloudeng.io/file/filewalk"
+	"cloudeng.io/file/filewalk/asyncstat"
+)
+
+// walkStats gathers the statistics displayed by --stats.
+type walkStats struct {
+	start            time.Time
+	dirsScanned      atomic.Int64
+	entries          atomic.Int64
+	scans            atomic.Int64
+	peakScans        atomic.Int64
+	synchronousScans atomic.Int64
+	syncStats        atomic.Int64
+	asyncStats       atomic.Int64
+	matches          atomic.Int64
+	bytesMatched     atomic.Int64
+	errorCount       atomic.Int64
+	queued           atomic.Int64
+	deepestDepth     atomic.Int64
+	exclude          *exclusions
+
+	mu      sync.Mutex
+	errors  map[string]int64
+	deepest string
+}
+
+func newWalkStats(exclude *exclusions) *walkStats {
+	return &walkStats{
+		start:   time.Now(),
+		exclude: exclude,
+		errors:  map[string]int64{},
+	}
+}
+
+// matched records a match, the size of files is added to the number
+// of bytes matched when it is known.
+func (ws *walkStats) matched(fi *file.Info) {
+	if ws == nil {
+		return
+	}
+	ws.matches.Add(1)
+	if fi != nil && !fi.IsDir() {
+		ws.bytesMatched.Add(fi.Size())
+	}
+}
+
+// error records an error of the specified class, as returned by
+// classifyError.
+func (ws *walkStats) error(class string) {
+	if ws == nil {
+		return
+	}
+	ws.errorCount.Add(1)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.errors[class]++
+}
+
+// queue records that n directories have been queued to be walked, or
+// dequeued if n is negative.
+func (ws *walkStats) queue(n int) {
+	if ws == nil {
+		return
+	}
+	ws.queued.Add(int64(n))
+}
+
+// scanned records that a directory is being scanned, keeping track of
+// the deepest directory scanned so far.
+func (ws *walkStats) scanned(path string) {
+	ws.dirsScanned.Add(1)
+	depth := int64(strings.Count(path, "/") + strings.Count(path, "\\"))
+	if depth <= ws.deepestDepth.Load() {
+		return
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if depth > ws.deepestDepth.Load() {
+		ws.deepestDepth.Store(depth)
+		ws.deepest = path
+	}
+}
+
+func (ws *walkStats) deepestPath() string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.deepest
+}
+
+// wrap returns a filewalk.FS that records the directories scanned using
+// fs, or fs itself if statistics are not being gathered.
+func (ws *walkStats) wrap(fs filewalk.FS) filewalk.FS {
+	if ws == nil {
+		return fs
+	}
+	return &statsFS{FS: fs, stats: ws}
+}
+
+// statsFor returns the walkStats for a filewalk.FS returned by wrap,
+// or nil.
+func statsFor(fs filewalk.FS) *walkStats {
+	if sfs, ok := fs.(*statsFS); ok {
+		return sfs.stats
+	}
+	return nil
+}
+
+// statsFS wraps a filewalk.FS to count the directories scanned, the
+// entries they contain and the number of concurrent scans.
+type statsFS struct {
+	filewalk.FS
+	stats *walkStats
+}
+
+func (sfs *statsFS) LevelScanner(path string) filewalk.LevelScanner {
+	sfs.stats.scanned(path)
+	return &statsScanner{LevelScanner: sfs.FS.LevelScanner(path), stats: sfs.stats}
+}
+
+type statsScanner struct {
+	filewalk.LevelScanner
+	stats *walkStats
+}
+
+func (ss *statsScanner) Scan(ctx context.Context, n int) bool {
+	ws := ss.stats
+	scans := ws.scans.Add(1)
+	for peak := ws.peakScans.Load(); scans > peak; peak = ws.peakScans.Load() {
+		if ws.peakScans.CompareAndSwap(peak, scans) {
+			break
+		}
+	}
+	defer ws.scans.Add(-1)
+	return ss.LevelScanner.Scan(ctx, n)
+}
+
+func (ss *statsScanner) Contents() []filewalk.Entry {
+	contents := ss.LevelScanner.Contents()
+	ss.stats.entries.Add(int64(len(contents)))
+	return contents
+}
+
+// statProcessor is implemented by asyncstat.T and statsProcessor.
+type statProcessor interface {
+	Process(ctx context.Context, prefix string, entries []filewalk.Entry) (children, all file.InfoList, err error)
+}
+
+// newStatProcessor returns stats, wrapped to count the number of
+// synchronous and asynchronous stat calls issued if ws is not nil.
+func newStatProcessor(stats *asyncstat.T, ws *walkStats) statProcessor {
+	if ws == nil {
+		return stats
+	}
+	return &statsProcessor{T: stats, threshold: stats.Configuration().AsyncThreshold, stats: ws}
+}
+
+// statsProcessor counts stat calls using the same threshold as
+// asyncstat.T to determine whether they are issued synchronously or
+// asynchronously.
+type statsProcessor struct {
+	*asyncstat.T
+	threshold int
+	stats     *walkStats
+}
+
+func (sp *statsProcessor) Process(ctx context.Context, prefix string, entries []filewalk.Entry) (children, all file.InfoList, err error) {
+	if len(entries) < sp.threshold {
+		sp.stats.syncStats.Add(int64(len(entries)))
+	} else {
+		sp.stats.asyncStats.Add(int64(len(entries)))
+	}
+	return sp.T.Process(ctx, prefix, entries)
+}
+
+// statsReport is the JSON form of the statistics.
+type statsReport struct {
+	WallTime            float64          `json:"wall_time_seconds"`
+	DirsScanned         int64            `json:"dirs_scanned"`
+	Entries             int64            `json:"entries"`
+	PeakConcurrentScans int64            `json:"peak_concurrent_scans"`
+	SynchronousScans    int64            `json:"synchronous_scans"`
+	SyncStats           int64            `json:"sync_stats"`
+	AsyncStats          int64            `json:"async_stats"`
+	Matches             int64            `json:"matches"`
+	BytesMatched        int64            `json:"bytes_matched"`
+	Errors              map[string]int64 `json:"errors"`
+	Exclusions          []exclusionCount `json:"exclusions,omitempty"`
+}
+
+func (ws *walkStats) statsReport() statsReport {
+	ws.mu.Lock()
+	errs := make(map[string]int64, len(ws.errors))
+	for k, v := range ws.errors {
+		errs[k] = v
+	}
+	ws.mu.Unlock()
+	return statsReport{
+		WallTime:            time.Since(ws.start).Seconds(),
+		DirsScanned:         ws.dirsScanned.Load(),
+		Entries:             ws.entries.Load(),
+		PeakConcurrentScans: ws.peakScans.Load(),
+		SynchronousScans:    ws.synchronousScans.Load(),
+		SyncStats:           ws.syncStats.Load(),
+		AsyncStats:          ws.asyncStats.Load(),
+		Matches:             ws.matches.Load(),
+		BytesMatched:        ws.bytesMatched.Load(),
+		Errors:              errs,
+		Exclusions:          ws.exclude.counts(),
+	}
+}
+
+// report writes the statistics to w in the specified format.
+func (ws *walkStats) report(w io.Writer, format string) error {
+	r := ws.statsReport()
+	if format == "json" {
+		return json.NewEncoder(w).Encode(r)
+	}
+	fmt.Fprintf(w, "stats: wall time: %v\n", time.Duration(r.WallTime*float64(time.Second)).Round(time.Millisecond))
+	fmt.Fprintf(w, "stats: directories scanned: %v\n", r.DirsScanned)
+	fmt.Fprintf(w, "stats: entries: %v\n", r.Entries)
+	fmt.Fprintf(w, "stats: peak concurrent scans: %v\n", r.PeakConcurrentScans)
+	fmt.Fprintf(w, "stats: synchronous scans: %v\n", r.SynchronousScans)
+	fmt.Fprintf(w, "stats: stat calls: %v sync, %v async\n", r.SyncStats, r.AsyncStats)
+	fmt.Fprintf(w, "stats: matches: %v\n", r.Matches)
+	fmt.Fprintf(w, "stats: bytes matched: %v (%v)\n", r.BytesMatched, diskusage.BinarySize(0, 2, r.BytesMatched))
+	classes := make([]string, 0, len(r.Errors))
+	for class := range r.Errors {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(w, "stats: errors: %v: %v\n", class, r.Errors[class])
+	}
+	ws.exclude.report(w)
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 cloudeng llc. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"cloudeng.io/file/filewalk/filewalktestutil"
+)
+
+func TestWalkStats(t *testing.T) {
+	ctx := context.Background()
+	fs, err := filewalktestutil.NewMockFS("r", filewalktestutil.WithYAMLConfig(topSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sorted := range []bool{false, true} {
+		exclude, err := newExclusions(nil, nil, []string{"none"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lf := &locateFlags{Sorted: sorted, Long: true, Depth: -1, Format: textFormat}
+		lf.ScanSize = 100
+		lf.ConcurrentStatsThreshold = 3
+		ws := newWalkStats(exclude)
+		wfs := ws.wrap(fs)
+		var out, errs bytes.Buffer
+		o := newOutput(&out, &errs, textFormat, false)
+		v := visit{ctx: ctx, fs: wfs, lf: lf, out: o, roots: []string{"r"}, stats: ws}
+		if err := (locateCmd{}).locateFS(ctx, wfs, lf, v.visit, []string{"r", "type=f"}, withExclusions(exclude)); err != nil {
+			t.Fatal(err)
+		}
+		r := ws.statsReport()
+		if got, want := r.DirsScanned, int64(2); got != want {
+			t.Errorf("sorted %v: dirs scanned: got %v, want %v", sorted, got, want)
+		}
+		if got, want := r.Entries, int64(6); got != want {
+			t.Errorf("sorted %v: entries: got %v, want %v", sorted, got, want)
+		}
+		if got, want := r.AsyncStats, int64(6); got != want {
+			t.Errorf("sorted %v: async stats: got %v, want %v", sorted, got, want)
+		}
+		if got, want := r.Matches, int64(5); got != want {
+			t.Errorf("sorted %v: matches: got %v, want %v", sorted, got, want)
+		}
+		if got, want := r.BytesMatched, int64(120); got != want {
+			t.Errorf("sorted %v: bytes matched: got %v, want %v", sorted, got, want)
+		}
+		if r.PeakConcurrentScans < 1 {
+			t.Errorf("sorted %v: peak concurrent scans: got %v", sorted, r.PeakConcurrentScans)
+		}
+
+		ws.error(classifyError(wfs, os.ErrNotExist))
+		ws.error(classifyError(wfs, fmt.Errorf("oops")))
+		var text bytes.Buffer
+		if err := ws.report(&text, textFormat); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range []string{
+			"stats: directories scanned: 2\n",
+			"stats: stat calls: 0 sync, 6 async\n",
+			"stats: matches: 5\n",
+			"stats: errors: notexist: 1\nstats: errors: other: 1\n",
+			"excluded: name:none: 0 directories\n",
+		} {
+			if !strings.Contains(text.String(), line) {
+				t.Errorf("sorted %v: %q not found in %v", sorted, line, text.String())
+			}
+		}
+		var js bytes.Buffer
+		if err := ws.report(&js, "json"); err != nil {
+			t.Fatal(err)
+		}
+		var decoded statsReport
+		if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := decoded.Errors["notexist"], int64(1); got != want {
+			t.Errorf("sorted %v: got %v, want %v", sorted, got, want)
+		}
+		if got, want := len(decoded.Exclusions), 1; got != want {
+			t.Errorf("sorted %v: got %v, want %v", sorted, got, want)
+		}
+	}
+}
+
+func TestWalkStatsInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lf := &locateFlags{Stats: true, StatsFormat: "json", Depth: -1, Format: textFormat}
+	err := (locateCmd{}).locate(ctx, lf, []string{localTestTree, "--", "name=no-such-file"})
+	if !errors.Is(err, errInterrupted) || errors.Is(err, errNoMatches) {
+		t.Errorf("got %v, want %v", err, errInterrupted)
+	}
+}